@@ -1,43 +1,747 @@
 package cmd
 
 import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gilramir/difftree/difftreelib"
 )
 
 type Application struct {
 	checkHashes     bool
+	checkRootExtras bool
+	detectRenames   bool
+	apply           bool
+	applyMissing    bool
+	applyPerms      bool
+	dryRun          bool
+	caseRenames     bool
+	hashAlgorithm   string
+	outputFormat    string
+	escapeNames     bool
+	prerender       bool
+	permFormat      string
+	allowPerms      permTransitions
+	allowPermsFile  string
+	blockCompare    bool
+	blockSize       int64
+	ignoreEmpty     bool
+	execOnly        bool
+	statsJSONName   string
+	metricsName     string
+	severity        bool
+	sqliteName      string
+	events          bool
+	treeHash        bool
+	printSchema     bool
+	timing          bool
+	treeView        bool
+	groupDirDiffs   bool
+	resolveTargets  bool
+	checkBirthtime  bool
+	checkProjectID  bool
+	checkInodeGen   bool
+	birthTolerance  time.Duration
+	checkDirMtime   bool
+	mtimePrecision  bool
+	dirMtimeSlop    time.Duration
+	combineMeta     bool
+	fullModeDiff    bool
+	contentType     bool
+	similarity      bool
+	exportDiffs     string
+	exportLimit     int64
+	permMask        octalMode
+	checkOwner      bool
+	ignoreUIDs      idRanges
+	ignoreGIDs      idRanges
+	ignoreBytes     byteRanges
+	checkXattrs     bool
+	ignoreXattrs    stringList
+	flagEscaping    bool
+	resolveContent  bool
+	verifyTargets   bool
+	ignoreBroken    bool
+	compareCommand  string
+	compareTimeout  time.Duration
+	flagPerms       octalMode
+	quickDirs       bool
+	dirEntryAttrs   bool
+	dirEntrySizes   bool
+	merkle          bool
+	sameInode       bool
+	skipDirs        bool
+	pathsFrom       string
+	baselineName    string
+	writeBaseline   string
+	writeMatched    string
+	skipMatched     string
+	compareReports  bool
+	manifestName    string
+	archiveName     string
+	allowSame       bool
+	expandDirDiffs  bool
+	separateDots    bool
+	ignoreDots      bool
+	workers         int
+	autoWorkers     bool
+	partition       bool
+	pipelineDepth   int
+	deadline        time.Duration
+	maxBytesRead    int64
+	countsInterval  time.Duration
+	profileName     string
+	hashRecordName  string
+	duplicates      bool
+	contentIndex    bool
+	alwaysHash      bool
+	detectTruncated bool
+	sampleRate      float64
+	sampleSeed      int64
+	hashSampleRate  float64
+	hashSampleSeed  int64
+	maxReport       int
+	maxRetries      int
+	showContents    bool
+	normalizeEOL    bool
+	ignoreCase      bool
+	ignoreTrailWS   bool
+	ignoreBOM       bool
+	textSizeLimit   int64
+	retryBackoff    time.Duration
+	reportAll       bool
 	logfileName     string
+	logLevel        string
+	logFormat       string
 	firstDirectory  string
 	secondDirectory string
 	ignoreFiles     []string
+	excludeDirs     stringList
+	metadataOnly    stringList
+	ignoreForExit   stringList
+	pruneDiffDirs   bool
+	dumpOrder       bool
+	structureOnly   bool
+	oneFileSystem   bool
+	includeMounts   stringList
+	mapPrefixes     stringList
+}
+
+// stringList is a flag.Value that collects a repeatable string flag
+type stringList []string
+
+func (self *stringList) String() string {
+	return strings.Join(*self, ",")
+}
+
+func (self *stringList) Set(value string) error {
+	*self = append(*self, value)
+	return nil
+}
+
+// permTransitions is a flag.Value that collects repeatable permission
+// changes, like "0644:0664"
+type permTransitions []difftreelib.PermTransition
+
+func (self *permTransitions) String() string {
+	transitions := make([]string, len(*self))
+	for i, t := range *self {
+		transitions[i] = fmt.Sprintf("%04o:%04o", t.From, t.To)
+	}
+	return strings.Join(transitions, ",")
+}
+
+func (self *permTransitions) Set(value string) error {
+	transition, err := difftreelib.ParsePermTransition(value)
+	if err != nil {
+		return err
+	}
+	*self = append(*self, transition)
+	return nil
+}
+
+// octalMode is a flag.Value for permission bits given in octal
+type octalMode os.FileMode
+
+func (self *octalMode) String() string {
+	return fmt.Sprintf("%04o", uint32(*self))
+}
+
+func (self *octalMode) Set(value string) error {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return err
+	}
+	if os.FileMode(mode)&^os.ModePerm != 0 {
+		return fmt.Errorf("%s has bits other than permissions", value)
+	}
+	*self = octalMode(mode)
+	return nil
+}
+
+// idRanges is a flag.Value that collects repeatable ranges of uids or
+// gids, like "900-999", or single ids, like "65534"
+type idRanges []difftreelib.IDRange
+
+func (self *idRanges) String() string {
+	ranges := make([]string, len(*self))
+	for i, r := range *self {
+		ranges[i] = fmt.Sprintf("%d-%d", r.First, r.Last)
+	}
+	return strings.Join(ranges, ",")
+}
+
+func (self *idRanges) Set(value string) error {
+	first, last := value, value
+	if i := strings.IndexByte(value, '-'); i != -1 {
+		first, last = value[:i], value[i+1:]
+	}
+	firstID, err := strconv.ParseUint(first, 10, 32)
+	if err != nil {
+		return err
+	}
+	lastID, err := strconv.ParseUint(last, 10, 32)
+	if err != nil {
+		return err
+	}
+	if firstID > lastID {
+		return fmt.Errorf("%s is an empty range", value)
+	}
+	*self = append(*self, difftreelib.IDRange{
+		First: uint32(firstID),
+		Last:  uint32(lastID),
+	})
+	return nil
+}
+
+// byteRanges is a flag.Value that collects repeatable ranges of byte
+// offsets, like "16-24", which includes 16 up to, but not including, 24
+type byteRanges []difftreelib.ByteRange
+
+func (self *byteRanges) String() string {
+	ranges := make([]string, len(*self))
+	for i, r := range *self {
+		ranges[i] = fmt.Sprintf("%d-%d", r.Start, r.End)
+	}
+	return strings.Join(ranges, ",")
+}
+
+func (self *byteRanges) Set(value string) error {
+	i := strings.IndexByte(value, '-')
+	if i == -1 {
+		return fmt.Errorf("%s is not START-END", value)
+	}
+	start, err := strconv.ParseInt(value[:i], 10, 64)
+	if err != nil {
+		return err
+	}
+	end, err := strconv.ParseInt(value[i+1:], 10, 64)
+	if err != nil {
+		return err
+	}
+	if start < 0 || start >= end {
+		return fmt.Errorf("%s is an empty range", value)
+	}
+	*self = append(*self, difftreelib.ByteRange{
+		Start: start,
+		End:   end,
+	})
+	return nil
 }
 
 func (self *Application) Run() {
 
 	flag.BoolVar(&self.checkHashes, "check-hashes", false, "Check sha1 of files")
+	flag.BoolVar(&self.alwaysHash, "always-hash", false,
+		"With -check-hashes, hash files even when their sizes differ")
+	flag.BoolVar(&self.detectTruncated, "detect-truncated", false,
+		"With -check-hashes, report files which are the start of their counterparts as truncated copies")
+	flag.StringVar(&self.hashAlgorithm, "hash", difftreelib.HashSHA1,
+		"Hash used by -check-hashes: sha1 or blake3")
+	flag.BoolVar(&self.checkBirthtime, "check-birthtime", false,
+		"Compare file creation times, where the system records them")
+	flag.DurationVar(&self.birthTolerance, "birthtime-tolerance", 0,
+		"Allowed difference between creation times")
+	flag.BoolVar(&self.checkProjectID, "check-project-id", false,
+		"Compare the project quota IDs of files and directories (XFS and ext4 on Linux)")
+	flag.BoolVar(&self.checkInodeGen, "check-inode-generation", false,
+		"Compare the inode generation numbers of files and directories (ext4 and XFS on Linux)")
+	flag.BoolVar(&self.checkDirMtime, "check-dir-mtime", false,
+		"Compare the modification times of directories")
+	flag.DurationVar(&self.dirMtimeSlop, "dir-mtime-tolerance", 0,
+		"Allowed difference between directory modification times")
+	flag.BoolVar(&self.mtimePrecision, "mtime-precision-only", false,
+		"With -check-dir-mtime, note mtimes which differ only in precision as DTMtimePrecision")
+	flag.Var(&self.permMask, "perm-mask",
+		"Only compare these permission bits, in octal (default 0777)")
+	flag.StringVar(&self.permFormat, "perm-format", difftreelib.PermFormatSymbolic,
+		"How to show permissions: symbolic or octal")
+	flag.Var(&self.allowPerms, "allow-perm-change",
+		"FROM:TO, in octal, like 0644:0664: a change of permissions to report as allowed (repeatable)")
+	flag.StringVar(&self.allowPermsFile, "allowed-perm-changes", "",
+		"File of permission changes to report as allowed, one FROM:TO per line")
+	flag.BoolVar(&self.checkOwner, "check-owner", false,
+		"Compare the owning uids and gids")
+	flag.Var(&self.ignoreUIDs, "ignore-uid",
+		"With -check-owner, ignore changes between uids in this range, like 900-999 (repeatable)")
+	flag.Var(&self.ignoreGIDs, "ignore-gid",
+		"With -check-owner, ignore changes between gids in this range, like 900-999 (repeatable)")
+	flag.BoolVar(&self.checkXattrs, "check-xattrs", false,
+		"Compare extended attributes")
+	flag.Var(&self.ignoreXattrs, "ignore-xattr",
+		"With -check-xattrs, don't compare this attribute, like security.selinux (repeatable)")
+	flag.BoolVar(&self.combineMeta, "combine-metadata-diffs", false,
+		"Report all metadata differences of a path together")
+	flag.BoolVar(&self.fullModeDiff, "full-mode-diff", false,
+		"Describe type and permission differences with the full modes of both paths")
+	flag.BoolVar(&self.similarity, "similarity", false,
+		"Show how similar mismatched files are, as a percentage")
+	flag.BoolVar(&self.contentType, "compare-content-type", false,
+		"Report files whose MIME types, guessed from their contents, differ")
+	flag.BoolVar(&self.resolveTargets, "resolve-symlink-targets", false,
+		"Compare symlinks by where they point within their trees, not by their text")
+	flag.StringVar(&self.compareCommand, "compare-command", "",
+		"Compare files by running this command with both paths; exit status 0 means they match")
+	flag.DurationVar(&self.compareTimeout, "compare-command-timeout", 0,
+		"How long -compare-command may run for one file (default 1m)")
+	flag.BoolVar(&self.resolveContent, "compare-resolved-content", false,
+		"Also compare symlinks by the contents of the files they finally resolve to")
+	flag.BoolVar(&self.verifyTargets, "verify-symlink-targets", false,
+		"Check that matching symlinks resolve, within their dirs, to matching files")
+	flag.BoolVar(&self.ignoreBroken, "ignore-broken-symlinks", false,
+		"Ignore symlinks which are broken in both dirs")
+	flag.BoolVar(&self.flagEscaping, "flag-escaping-symlinks", false,
+		"Warn about symlinks that point outside their tree")
+	flag.Var(&self.flagPerms, "flag-perms",
+		"Warn about paths with any of these permission bits, in octal, like 0002")
+	flag.BoolVar(&self.blockCompare, "block-compare", false,
+		"Compare files block by block and list the blocks that differ")
+	flag.Int64Var(&self.blockSize, "block-size", 0,
+		"Block size, in bytes, for -block-compare (default 1MB)")
+	flag.Var(&self.ignoreBytes, "ignore-bytes",
+		"START-END: compare file contents without the bytes from START up to END (repeatable)")
+	flag.BoolVar(&self.ignoreEmpty, "ignore-empty-files", false,
+		"Don't compare files if either one is empty")
+	flag.BoolVar(&self.execOnly, "executables-only", false,
+		"Only compare files that are executable in the first dir")
+	flag.BoolVar(&self.detectRenames, "detect-renames", false,
+		"Report files missing from the second dir, but found elsewhere in it, as moved")
+	flag.BoolVar(&self.apply, "apply", false,
+		"Change the second dir to match the first: both -apply-missing and -apply-perms")
+	flag.BoolVar(&self.applyMissing, "apply-missing", false,
+		"Copy files, dirs and symlinks missing from the second dir into it")
+	flag.BoolVar(&self.applyPerms, "apply-perms", false,
+		"Copy the permissions of paths whose permissions differ to the second dir")
+	flag.BoolVar(&self.dryRun, "dry-run", false,
+		"With -apply, only report the changes which would be made")
+	flag.BoolVar(&self.caseRenames, "detect-case-renames", false,
+		"Report files missing from the second dir, but found beside themselves with a name differing in case, as renamed")
+	flag.BoolVar(&self.checkRootExtras, "check-root-extras", false,
+		"Report top-level entries of the second dir missing from the first")
 	flag.StringVar(&self.logfileName, "log-file", "", "Where to log")
+	flag.StringVar(&self.logLevel, "log-level", "info",
+		"How much to log: error, info, or debug for a line per path")
+	flag.StringVar(&self.logFormat, "log-format", "full",
+		"What starts each log line: full for the time and source line, time, or plain")
+	flag.BoolVar(&self.quickDirs, "quick-dirs", false,
+		"Only count directory entries when the counts differ; don't list them")
+	flag.BoolVar(&self.dirEntryAttrs, "dir-entry-attrs", false,
+		"Also report dirs whose entries have the same names, but different types")
+	flag.BoolVar(&self.dirEntrySizes, "dir-entry-sizes", false,
+		"With -dir-entry-attrs, also compare the sizes of files")
+	flag.BoolVar(&self.merkle, "merkle", false,
+		"Hash each directory's subtree first, and don't compare subtrees with the same hash")
+	flag.BoolVar(&self.sameInode, "skip-same-inode", false,
+		"Don't compare paths which are hard links to the same file")
+	flag.IntVar(&self.workers, "workers", 0,
+		"Number of comparison workers (default: number of CPUs)")
+	flag.BoolVar(&self.autoWorkers, "auto-workers", false,
+		"Use more workers when the two dirs are on different devices")
+	flag.BoolVar(&self.partition, "partition-top-level", false,
+		"Walk each top-level directory in parallel, with its own workers")
+	flag.BoolVar(&self.duplicates, "duplicates", false,
+		"With -check-hashes, list the files with the same contents within each tree")
+	flag.BoolVar(&self.contentIndex, "content-index", false,
+		"With -check-hashes, also list the contents only in one tree, wherever they are")
+	flag.StringVar(&self.hashRecordName, "record-hashes", "",
+		"With -check-hashes, write the path, both hashes, and result of every file to this file")
+	flag.StringVar(&self.profileName, "profile-folded", "",
+		"Write the time spent comparing each directory to this file, as folded stacks for flamegraphs")
+	flag.DurationVar(&self.countsInterval, "counts-every", 0,
+		"Write the counts so far to stderr at this interval, like 10s")
+	flag.DurationVar(&self.deadline, "deadline", 0,
+		"Stop comparing after this long, report what was found, and exit with status 2")
+	flag.Int64Var(&self.maxBytesRead, "max-bytes-read", 0,
+		"Stop comparing after reading this many bytes of file contents, report what was found, "+
+			"and exit with status 2")
+	flag.IntVar(&self.pipelineDepth, "pipeline-depth", 1,
+		"Multiply the number of paths in flight, to hide filesystem latency")
+	flag.Float64Var(&self.sampleRate, "sample-rate", 0,
+		"Compare only this fraction (0 to 1) of files, chosen at random")
+	flag.Int64Var(&self.sampleSeed, "sample-seed", 1,
+		"Random seed for -sample-rate")
+	flag.Float64Var(&self.hashSampleRate, "hash-sample-rate", 0,
+		"Without -check-hashes, hash this fraction (0-1) of the files whose sizes match")
+	flag.Int64Var(&self.hashSampleSeed, "hash-sample-seed", 1,
+		"Random seed for -hash-sample-rate")
+	flag.BoolVar(&self.skipDirs, "skip-dir-comparison", false,
+		"Don't compare the entries of directories; only compare files")
+	flag.StringVar(&self.baselineName, "baseline", "",
+		"Don't report the expected differences listed in this file")
+	flag.StringVar(&self.writeBaseline, "write-baseline", "",
+		"Write the differences found to this file, for use with -baseline")
+	flag.StringVar(&self.writeMatched, "write-matched", "",
+		"With -check-hashes, write the files which matched, with their sizes and mtimes, to this file")
+	flag.StringVar(&self.skipMatched, "skip-matched", "",
+		"Don't read again the files in this -write-matched file whose sizes and mtimes are unchanged")
+	flag.BoolVar(&self.separateDots, "separate-dotfile-diffs", false,
+		"Report dirs whose entries differ only in dotfiles as DTDiffDotfiles")
+	flag.BoolVar(&self.ignoreDots, "ignore-dotfiles", false,
+		"Skip files and dirs whose names start with \".\"")
+	flag.BoolVar(&self.expandDirDiffs, "expand-dir-diffs", false,
+		"Report each entry only in the second dir as its own DTExtra result")
+	flag.BoolVar(&self.groupDirDiffs, "group-dir-diffs", false,
+		"Print the directory structure differences together, after the file results")
+	flag.BoolVar(&self.allowSame, "allow-same", false,
+		"Compare even if both dirs are the same directory")
+	flag.StringVar(&self.manifestName, "verify-manifest", "",
+		"Instead of 2 dirs, give 1, and verify it against this sha256sum-style or BSD-style checksum file")
+	flag.StringVar(&self.archiveName, "verify-archive", "",
+		"Instead of 2 dirs, give 1, and check it has the paths, types and sizes listed in this tar or zip file")
+	flag.BoolVar(&self.compareReports, "compare-reports", false,
+		"Instead of 2 dirs, give 2 files from -write-baseline, and show which differences appeared or were resolved")
+	flag.StringVar(&self.pathsFrom, "paths-from", "",
+		"Compare only the relative paths listed in this file (\"-\" for stdin)")
+	flag.IntVar(&self.maxReport, "max-report", 0,
+		"Stop printing results after this many (0 = no limit)")
+	flag.BoolVar(&self.reportAll, "all", false,
+		"Print all results, overriding -max-report")
+	flag.IntVar(&self.maxRetries, "max-retries", 0,
+		"Retry transient I/O errors (EINTR, ESTALE) this many times")
+	flag.DurationVar(&self.retryBackoff, "retry-backoff", 100*time.Millisecond,
+		"Wait before the first retry; doubles with each retry")
+	flag.BoolVar(&self.showContents, "show-contents", false,
+		"Show the contents of small mismatched text files")
+	flag.BoolVar(&self.normalizeEOL, "normalize-line-endings", false,
+		"Treat CRLF and LF line endings in text files as equal")
+	flag.BoolVar(&self.ignoreCase, "ignore-case-in-content", false,
+		"Compare the contents (not names) of text files case-insensitively")
+	flag.BoolVar(&self.ignoreTrailWS, "ignore-trailing-whitespace", false,
+		"Ignore spaces and tabs at the ends of lines in text files")
+	flag.BoolVar(&self.ignoreBOM, "ignore-bom", false,
+		"Ignore a UTF-8 byte order mark at the start of text files")
+	flag.Int64Var(&self.textSizeLimit, "text-size-limit", 0,
+		"Largest text file, in bytes, to read into memory (default 4096)")
+	flag.StringVar(&self.exportDiffs, "export-diffs", "",
+		"Copy both versions of each mismatched file into this directory")
+	flag.Int64Var(&self.exportLimit, "export-size-limit", 0,
+		"Largest file, in bytes, to copy for -export-diffs (default 10MB)")
+	flag.BoolVar(&self.treeHash, "tree-hash", false,
+		"Print a hash of each whole tree instead of comparing them")
+	flag.BoolVar(&self.timing, "timing", false,
+		"Print how long each stage of the comparison took")
+	flag.BoolVar(&self.treeView, "tree", false,
+		"Print the differences as an indented tree, after the comparison")
+	flag.StringVar(&self.outputFormat, "format", difftreelib.OutputText,
+		"How to print differences: text, github for GitHub Actions annotations, html for a page, "+
+			"or itemize for rsync --itemize-changes lines")
+	flag.BoolVar(&self.escapeNames, "escape-invalid-names", false,
+		"Print names which aren't valid UTF-8 with \\xHH escapes instead of their raw bytes")
+	flag.BoolVar(&self.dumpOrder, "dump-order", false,
+		"Print the walk order and path of each result to stderr, as it arrives")
+	flag.BoolVar(&self.events, "events", false,
+		"Write a stream of JSON objects to stdout instead of the report")
+	flag.BoolVar(&self.prerender, "prerender-output", false,
+		"Format each result's output in the comparison workers, for very many results")
+	flag.BoolVar(&self.printSchema, "print-schema", false,
+		"Print the JSON Schema of the -events and -stats-json output, and exit")
+	flag.StringVar(&self.metricsName, "metrics", "",
+		"Write the result counts to this file as Prometheus metrics")
+	flag.StringVar(&self.statsJSONName, "stats-json", "",
+		"Also write the summary counts as JSON to this file")
+	flag.BoolVar(&self.severity, "severity", false,
+		"Print how far apart the trees are: identical, metadata-only, content-drift, "+
+			"structural-drift or errors, and exit with status 0, or 11 to 14 respectively")
+	flag.Var(&self.ignoreForExit, "ignore-for-exit",
+		"With -severity, results like DTDiffDirMtime to report but leave out of the exit status; "+
+			"comma-separated or repeatable")
+	flag.StringVar(&self.sqliteName, "sqlite", "",
+		"Also write each result to a \"results\" table in this SQLite database")
+	flag.Var(&self.mapPrefixes, "map-prefix",
+		"FROM=TO: compare paths under FROM in the first dir with those under TO in the second; "+
+			"either may be empty (repeatable)")
+	flag.BoolVar(&self.oneFileSystem, "one-file-system", false,
+		"Don't descend into directories of the first dir on other filesystems")
+	flag.Var(&self.includeMounts, "include-mount",
+		"With -one-file-system, a mount point, relative to the first dir, to descend into anyway (repeatable)")
+	flag.Var(&self.excludeDirs, "exclude-dir",
+		"Directory to skip; \"./path\" is anchored at the root, "+
+			"\"name\" matches at any depth (repeatable)")
+	flag.BoolVar(&self.structureOnly, "structure-only", false,
+		"Only compare which paths exist, and their types; not metadata or contents")
+	flag.BoolVar(&self.pruneDiffDirs, "prune-differing-dirs", false,
+		"Don't descend into directories whose perms or other metadata differ")
+	flag.Var(&self.metadataOnly, "metadata-only",
+		"Files whose contents aren't compared, only their metadata, like \"*.log\" or \"var/cache/*\" (repeatable)")
 
 	flag.Parse()
 
-	if flag.NArg() != 2 {
-		fmt.Println("Must give 2 dirs")
+	if self.printSchema {
+		if err := difftreelib.WriteSchema(os.Stdout); err != nil {
+			fmt.Printf("Error: %q", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if self.manifestName != "" && self.archiveName != "" {
+		fmt.Println("Cannot use -verify-manifest with -verify-archive")
 		os.Exit(1)
 	}
-	self.firstDirectory = flag.Arg(0)
-	self.secondDirectory = flag.Arg(1)
+	if len(self.ignoreForExit) > 0 && !self.severity {
+		fmt.Println("-ignore-for-exit needs -severity")
+		os.Exit(1)
+	}
+	if self.manifestName != "" {
+		if flag.NArg() != 1 {
+			fmt.Println("Must give 1 dir to verify against the manifest")
+			os.Exit(1)
+		}
+		self.firstDirectory = flag.Arg(0)
+	} else if self.archiveName != "" {
+		if flag.NArg() != 1 {
+			fmt.Println("Must give 1 dir to verify against the archive")
+			os.Exit(1)
+		}
+		self.firstDirectory = flag.Arg(0)
+	} else {
+		if flag.NArg() != 2 {
+			fmt.Println("Must give 2 dirs")
+			os.Exit(1)
+		}
+		self.firstDirectory = flag.Arg(0)
+		self.secondDirectory = flag.Arg(1)
+	}
 
-	setLogger(self.logfileName)
+	if self.compareReports {
+		self.printReportChanges()
+		return
+	}
+
+	setLogger(self.logfileName, self.logFormat, self.logLevel)
 
 	var engine difftreelib.ComparisonEngine
 	var options difftreelib.DifftreeOptions
 
 	options.CheckHashes = self.checkHashes
+	options.ReportDuplicates = self.duplicates
+	options.ContentIndexMode = self.contentIndex
+	options.AlwaysHash = self.alwaysHash
+	options.DetectTruncated = self.detectTruncated
+	options.HashAlgorithm = self.hashAlgorithm
+	options.OutputFormat = self.outputFormat
+	options.EscapeInvalidNames = self.escapeNames
+	options.PrerenderOutput = self.prerender
+	for _, tags := range self.ignoreForExit {
+		options.IgnoreForExit = append(options.IgnoreForExit, strings.Split(tags, ",")...)
+	}
+	options.PermFormat = self.permFormat
+	options.AllowedPermChanges = self.allowPerms
+	if self.allowPermsFile != "" {
+		transitions, err := readPermTransitions(self.allowPermsFile)
+		if err != nil {
+			fmt.Printf("Error: %q", err)
+			os.Exit(1)
+		}
+		options.AllowedPermChanges = append(options.AllowedPermChanges, transitions...)
+	}
+	options.BlockCompare = self.blockCompare
+	options.IgnoreByteRanges = self.ignoreBytes
+	options.BlockSize = self.blockSize
+	options.CheckBirthtime = self.checkBirthtime
+	options.CheckProjectID = self.checkProjectID
+	options.CheckInodeGeneration = self.checkInodeGen
+	options.BirthtimeTolerance = self.birthTolerance
+	options.CheckDirMtime = self.checkDirMtime
+	options.MtimePrecisionOnly = self.mtimePrecision
+	options.DirMtimeTolerance = self.dirMtimeSlop
+	options.PermMask = os.FileMode(self.permMask)
+	options.CheckOwnership = self.checkOwner
+	options.IgnoreUIDs = self.ignoreUIDs
+	options.IgnoreGIDs = self.ignoreGIDs
+	options.CheckXattrs = self.checkXattrs
+	options.IgnoreXattrs = self.ignoreXattrs
+	options.CombineMetadataDiffs = self.combineMeta
+	options.ShowFullModeDiff = self.fullModeDiff
+	options.CompareContentType = self.contentType
+	options.SimilarityCompare = self.similarity
+	options.ResolveSymlinkTargets = self.resolveTargets
+	options.FlagEscapingSymlinks = self.flagEscaping
+	options.CompareResolvedContent = self.resolveContent
+	options.VerifySymlinkTargets = self.verifyTargets
+	options.IgnoreBrokenSymlinks = self.ignoreBroken
+	options.CompareCommand = self.compareCommand
+	options.CompareCommandTimeout = self.compareTimeout
+	options.FlagPermissions = os.FileMode(self.flagPerms)
+	options.IgnoreEmptyFiles = self.ignoreEmpty
+	options.ExecutablesOnly = self.execOnly
+	options.Timing = self.timing
+	options.TreeView = self.treeView
+	options.GroupDirDiffs = self.groupDirDiffs
+	if self.events {
+		options.Events = os.Stdout
+		options.Quiet = true
+	}
+	options.QuickDirCompare = self.quickDirs
+	options.CompareDirEntryAttrs = self.dirEntryAttrs
+	options.CompareDirEntrySizes = self.dirEntrySizes
+	options.SkipSameInode = self.sameInode
+	options.DirectoryMerkle = self.merkle
+	options.SkipDirectoryComparison = self.skipDirs
+	switch self.pathsFrom {
+	case "":
+	case "-":
+		options.PathsFrom = os.Stdin
+	default:
+		fh, err := os.Open(self.pathsFrom)
+		if err != nil {
+			fmt.Printf("Error: %q", err)
+			os.Exit(1)
+		}
+		defer fh.Close()
+		options.PathsFrom = fh
+	}
+	if self.sqliteName != "" {
+		db, err := sql.Open("sqlite", self.sqliteName)
+		if err != nil {
+			fmt.Printf("Error: %q", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+		options.ResultsDB = db
+	}
+	if self.baselineName != "" {
+		fh, err := os.Open(self.baselineName)
+		if err != nil {
+			fmt.Printf("Error: %q", err)
+			os.Exit(1)
+		}
+		defer fh.Close()
+		options.Baseline = fh
+	}
+	if self.profileName != "" {
+		fh, err := os.Create(self.profileName)
+		if err != nil {
+			fmt.Printf("Error: %q", err)
+			os.Exit(1)
+		}
+		defer fh.Close()
+		options.Profile = fh
+	}
+	if self.hashRecordName != "" {
+		fh, err := os.Create(self.hashRecordName)
+		if err != nil {
+			fmt.Printf("Error: %q", err)
+			os.Exit(1)
+		}
+		defer fh.Close()
+		options.RecordAllHashes = true
+		options.HashRecord = fh
+	}
+	if self.writeBaseline != "" {
+		fh, err := os.Create(self.writeBaseline)
+		if err != nil {
+			fmt.Printf("Error: %q", err)
+			os.Exit(1)
+		}
+		defer fh.Close()
+		options.WriteBaseline = fh
+	}
+	if self.skipMatched != "" {
+		matched, err := readMatchedFiles(self.skipMatched)
+		if err != nil {
+			fmt.Printf("Error: %q", err)
+			os.Exit(1)
+		}
+		options.SkipMatched = matched
+	}
+	if self.writeMatched != "" {
+		fh, err := os.Create(self.writeMatched)
+		if err != nil {
+			fmt.Printf("Error: %q", err)
+			os.Exit(1)
+		}
+		defer fh.Close()
+		options.WriteMatched = fh
+	}
+	options.Workers = self.workers
+	options.AutoTuneWorkers = self.autoWorkers
+	options.PartitionTopLevel = self.partition
+	options.PipelineDepth = self.pipelineDepth
+	options.Deadline = self.deadline
+	options.MaxTotalBytesRead = self.maxBytesRead
+	options.CountsInterval = self.countsInterval
+	options.SampleRate = self.sampleRate
+	options.SampleSeed = self.sampleSeed
+	options.HashSampleRate = self.hashSampleRate
+	options.HashSampleSeed = self.hashSampleSeed
+	options.MaxRetries = self.maxRetries
+	options.ShowSmallFileContents = self.showContents
+	options.NormalizeLineEndings = self.normalizeEOL
+	options.CaseInsensitiveContent = self.ignoreCase
+	options.IgnoreTrailingWhitespace = self.ignoreTrailWS
+	options.IgnoreBOM = self.ignoreBOM
+	options.TextSizeLimit = self.textSizeLimit
+	options.ExportDiffsDir = self.exportDiffs
+	options.ExportSizeLimit = self.exportLimit
+	options.RetryBackoff = self.retryBackoff
+	if !self.reportAll {
+		options.MaxReport = self.maxReport
+	}
+	options.ExcludeDirs = self.excludeDirs
+	options.MetadataOnlyPatterns = self.metadataOnly
+	options.PruneDifferingDirs = self.pruneDiffDirs
+	options.StructureOnly = self.structureOnly
+	if self.dumpOrder {
+		options.DumpOrder = os.Stderr
+	}
+	options.OneFileSystem = self.oneFileSystem
+	options.IncludeMounts = self.includeMounts
+	options.CheckRootExtras = self.checkRootExtras
+	options.AllowSameRoot = self.allowSame
+	options.ExpandDirDiffs = self.expandDirDiffs
+	options.SeparateDotfileDiffs = self.separateDots
+	options.IgnoreDotfiles = self.ignoreDots
+	if len(self.mapPrefixes) > 0 {
+		var rules []difftreelib.PrefixRule
+		for _, mapping := range self.mapPrefixes {
+			i := strings.IndexByte(mapping, '=')
+			if i == -1 {
+				fmt.Printf("Error: -map-prefix %q is not FROM=TO", mapping)
+				os.Exit(1)
+			}
+			rules = append(rules, difftreelib.PrefixRule{
+				From: mapping[:i],
+				To:   mapping[i+1:],
+			})
+		}
+		options.PathMap = difftreelib.PrefixPathMap(rules)
+	}
+	options.DetectRenames = self.detectRenames
+	options.DetectCaseRenames = self.caseRenames
+	options.ApplyMissing = self.apply || self.applyMissing
+	options.ApplyPerms = self.apply || self.applyPerms
+	options.ApplyDryRun = self.dryRun
 
 	/*
 		options.IgnoreFiles = make(map[string]bool)
@@ -46,16 +750,228 @@ func (self *Application) Run() {
 		}
 	*/
 
-	err := engine.Compare(self.firstDirectory, self.secondDirectory, &options)
+	if self.treeHash {
+		self.printTreeHashes(&options)
+		return
+	}
+
+	finishInterrupts := stopOnInterrupt(&engine)
+	var err error
+	if self.manifestName != "" {
+		err = self.verifyManifest(&engine, &options)
+	} else if self.archiveName != "" {
+		err = engine.VerifyArchive(self.archiveName, self.firstDirectory, &options)
+	} else {
+		err = engine.Compare(self.firstDirectory, self.secondDirectory, &options)
+	}
+	interrupted := finishInterrupts()
+
+	// Write the stats even if the comparison failed part-way
+	if self.statsJSONName != "" {
+		statsErr := writeStatsJSON(self.statsJSONName, engine.Stats())
+		if statsErr != nil {
+			fmt.Fprintf(os.Stderr, "Cannot write stats: %v\n", statsErr)
+		}
+	}
+	if self.metricsName != "" {
+		metricsErr := self.writeMetrics(engine.Stats())
+		if metricsErr != nil {
+			fmt.Fprintf(os.Stderr, "Cannot write metrics: %v\n", metricsErr)
+		}
+	}
+
 	if err != nil {
 		fmt.Printf("Error: %q", err)
 		os.Exit(1)
 	}
 
-	engine.Summarize()
+	// The event stream and the HTML page end with their own summaries
+	if !self.events && self.outputFormat != difftreelib.OutputHTML &&
+		self.outputFormat != difftreelib.OutputItemize {
+		engine.Summarize()
+		if self.timing {
+			engine.SummarizeTiming()
+		}
+		if self.severity {
+			fmt.Printf("\nSEVERITY: %s\n", engine.Stats().Severity())
+			if exitSeverity := engine.ExitSeverity(); exitSeverity != engine.Stats().Severity() {
+				fmt.Printf("SEVERITY FOR THE EXIT STATUS: %s, without %s\n",
+					exitSeverity, strings.Join(options.IgnoreForExit, ", "))
+			}
+		}
+	}
+
+	// Distinguish a comparison cut short from one which completed
+	if interrupted {
+		os.Exit(interruptedExitStatus)
+	}
+	if engine.Stats().Incomplete {
+		os.Exit(2)
+	}
+
+	if self.severity {
+		if severity := engine.ExitSeverity(); severity != difftreelib.SeverityIdentical {
+			os.Exit(severityExitBase + int(severity))
+		}
+	}
+}
+
+// The exit status after an interrupt, as a shell reports for SIGINT
+const interruptedExitStatus = 130
+
+// On the first interrupt, stop the comparison, so that what was found
+// is still reported; a second one kills the process, as usual. The
+// returned function stops handling interrupts, and returns whether
+// there was one.
+func stopOnInterrupt(engine *difftreelib.ComparisonEngine) func() bool {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	done := make(chan struct{})
+	result := make(chan bool)
+
+	go func() {
+		select {
+		case <-interrupts:
+			signal.Stop(interrupts)
+			fmt.Fprintln(os.Stderr, "Interrupted; reporting what was found so far")
+			engine.Stop()
+			<-done
+			result <- true
+		case <-done:
+			result <- false
+		}
+	}()
+
+	return func() bool {
+		signal.Stop(interrupts)
+		close(done)
+		return <-result
+	}
+}
+
+// With -severity, the exit status for each Severity other than
+// identical; 1 and 2 are already taken by errors, and by -deadline
+// and -max-bytes-read.
+const severityExitBase = 10
+
+func (self *Application) printTreeHashes(options *difftreelib.DifftreeOptions) {
+	var hashes [2][]byte
+	for i, root := range []string{self.firstDirectory, self.secondDirectory} {
+		hash, err := difftreelib.TreeHash(root, options)
+		if err != nil {
+			fmt.Printf("Error: %q", err)
+			os.Exit(1)
+		}
+		hashes[i] = hash
+		fmt.Printf("%x  %s\n", hash, root)
+	}
+
+	if bytes.Equal(hashes[0], hashes[1]) {
+		fmt.Println("The trees are identical")
+	} else {
+		fmt.Println("The trees differ")
+	}
 }
 
-func setLogger(logfileName string) {
+func (self *Application) verifyManifest(engine *difftreelib.ComparisonEngine,
+	options *difftreelib.DifftreeOptions) error {
+
+	manifest, err := os.Open(self.manifestName)
+	if err != nil {
+		return err
+	}
+	defer manifest.Close()
+	return engine.VerifyManifest(manifest, self.firstDirectory, options)
+}
+
+// With -compare-reports, the two positional arguments are reports
+func (self *Application) printReportChanges() {
+	oldReport, err := os.Open(self.firstDirectory)
+	if err != nil {
+		fmt.Printf("Error: %q", err)
+		os.Exit(1)
+	}
+	defer oldReport.Close()
+	newReport, err := os.Open(self.secondDirectory)
+	if err != nil {
+		fmt.Printf("Error: %q", err)
+		os.Exit(1)
+	}
+	defer newReport.Close()
+
+	err = difftreelib.CompareReports(oldReport, newReport, os.Stdout)
+	if err != nil {
+		fmt.Printf("Error: %q", err)
+		os.Exit(1)
+	}
+}
+
+func readMatchedFiles(filename string) (difftreelib.MatchedFiles, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return difftreelib.ReadMatchedFiles(fh)
+}
+
+func readPermTransitions(filename string) ([]difftreelib.PermTransition, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return difftreelib.ReadPermTransitions(fh)
+}
+
+// The keys are documented on difftreelib.Stats
+func writeStatsJSON(filename string, stats difftreelib.Stats) error {
+	data, err := json.MarshalIndent(&stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// The metrics are written to a temporary file which is then renamed,
+// so that a collector never reads a partial file
+func (self *Application) writeMetrics(stats difftreelib.Stats) error {
+	tmpName := self.metricsName + ".tmp"
+	fh, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	err = stats.WriteMetrics(fh, self.firstDirectory, self.secondDirectory, time.Now())
+	if closeErr := fh.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, self.metricsName)
+}
+
+// The log flags for each -log-format
+var logFormats = map[string]int{
+	"full":  log.Ldate | log.Lmicroseconds | log.Lshortfile,
+	"time":  log.Ldate | log.Lmicroseconds,
+	"plain": 0,
+}
+
+func setLogger(logfileName string, logFormat string, logLevel string) {
+	flags, ok := logFormats[logFormat]
+	if !ok {
+		fmt.Printf("Unknown -log-format %q; must be full, time or plain\n", logFormat)
+		os.Exit(1)
+	}
+	level, err := difftreelib.ParseLogLevel(logLevel)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	difftreelib.SetLogLevel(level)
+
 	switch logfileName {
 	case "":
 		log.SetOutput(ioutil.Discard)
@@ -69,5 +985,5 @@ func setLogger(logfileName string) {
 		}
 		log.SetOutput(fh)
 	}
-	log.SetFlags(log.Ldate | log.Lmicroseconds | log.Lshortfile)
+	log.SetFlags(flags)
 }
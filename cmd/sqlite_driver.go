@@ -0,0 +1,8 @@
+//go:build linux || darwin || windows
+// +build linux darwin windows
+
+package cmd
+
+// The pure-Go SQLite driver for -sqlite doesn't support the other
+// platforms. There, sql.Open reports that the driver is unknown.
+import _ "modernc.org/sqlite"
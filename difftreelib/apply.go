@@ -0,0 +1,100 @@
+package difftreelib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// The permission bits which ApplyPerms copies
+const appliedModeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+func (self *DifftreeOptions) applies() bool {
+	return self.ApplyMissing || self.ApplyPerms
+}
+
+// Make tree2 match tree1 for this difference, if it is one of the
+// kinds chosen to be applied, and report what was done. Results are
+// handled out of walk order, so the parents of a missing path are
+// created as needed.
+func (s *ComparisonEngine) applyFix(entry *treeEntry, options *DifftreeOptions) {
+	var action string
+	var fix func() error
+
+	switch {
+	case entry.result == kMissing && options.ApplyMissing && entry.info1 != nil:
+		mode := entry.info1.Mode()
+		switch {
+		case mode.IsDir():
+			action = fmt.Sprintf("create directory %s", entry.path2)
+			fix = func() error {
+				if err := os.MkdirAll(entry.path2, 0755); err != nil {
+					return err
+				}
+				return os.Chmod(entry.path2, mode&appliedModeBits)
+			}
+		case mode.IsRegular():
+			action = fmt.Sprintf("copy %s to %s", entry.path1, entry.path2)
+			fix = func() error {
+				if err := os.MkdirAll(filepath.Dir(entry.path2), 0755); err != nil {
+					return err
+				}
+				if err := copyFile(entry.path1, entry.path2); err != nil {
+					return err
+				}
+				return os.Chmod(entry.path2, mode&appliedModeBits)
+			}
+		case mode&os.ModeSymlink != 0:
+			target, err := os.Readlink(entry.path1)
+			if err != nil {
+				logError("Cannot apply %s: %v", entry.path1, err)
+				return
+			}
+			action = fmt.Sprintf("link %s to %q", entry.path2, target)
+			fix = func() error {
+				if err := os.MkdirAll(filepath.Dir(entry.path2), 0755); err != nil {
+					return err
+				}
+				return os.Symlink(target, entry.path2)
+			}
+		default:
+			logError("Not applying %s: cannot create a %s", entry.path1,
+				translateModeType(mode&os.ModeType))
+			return
+		}
+
+	case entry.result == kDifferentPermissions && options.ApplyPerms:
+		mode := entry.info1.Mode() & appliedModeBits
+		action = fmt.Sprintf("chmod %s to %s", entry.path2, options.formatPerms(mode))
+		fix = func() error {
+			return os.Chmod(entry.path2, mode)
+		}
+
+	default:
+		return
+	}
+
+	if options.ApplyDryRun {
+		s.stats.WouldApply++
+		s.reportFix("DRY RUN: would %s", action)
+		return
+	}
+	if err := fix(); err != nil {
+		s.stats.ApplyFailed++
+		s.reportFix("APPLY FAILED: %s: %v", action, err)
+		return
+	}
+	s.stats.Applied++
+	s.reportFix("APPLIED: %s", action)
+}
+
+// The fixes go in the report, except that the events stream is JSON,
+// the HTML page is written at the end, and the itemized lines are
+// for rsync's parsers
+func (s *ComparisonEngine) reportFix(format string, args ...interface{}) {
+	if s.events != nil || s.htmlReport != nil || s.itemize {
+		logInfo(format, args...)
+		return
+	}
+	fmt.Printf(format+"\n\n", args...)
+}
@@ -0,0 +1,53 @@
+package difftreelib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyMissing(t *testing.T) {
+	tests := []struct {
+		name          string
+		detectRenames bool
+		wantOld       bool
+	}{
+		{"without DetectRenames", false, true},
+		// The moved file isn't copied back to its old path
+		{"with DetectRenames", true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path1, path2, cleanup := makeTrees(t,
+				testTree{
+					"onlyin1": "only in tree1",
+					"old":     "moved",
+				},
+				testTree{
+					"new": "moved",
+				})
+			defer cleanup()
+
+			options := DifftreeOptions{
+				ApplyMissing:  true,
+				DetectRenames: test.detectRenames,
+			}
+			captureStdout(t, func() {
+				compareQuietly(t, path1, path2, &options)
+			})
+
+			data, err := ioutil.ReadFile(filepath.Join(path2, "onlyin1"))
+			if err != nil {
+				t.Fatalf("onlyin1 was not applied: %v", err)
+			}
+			if string(data) != "only in tree1" {
+				t.Errorf("onlyin1 = %q", data)
+			}
+			_, err = os.Lstat(filepath.Join(path2, "old"))
+			if gotOld := err == nil; gotOld != test.wantOld {
+				t.Errorf("old applied = %v, want %v", gotOld, test.wantOld)
+			}
+		})
+	}
+}
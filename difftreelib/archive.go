@@ -0,0 +1,272 @@
+package difftreelib
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// An entry of an archive's table of contents: the tar headers, or the
+// zip central directory. Nothing else of the archive is read.
+type archiveEntry struct {
+	path string
+	mode os.FileMode
+	size int64
+
+	// A tar hard link has no size of its own
+	hardLink bool
+}
+
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	emptyZipMagic = []byte("PK\x05\x06")
+	gzipMagic     = []byte("\x1f\x8b")
+)
+
+// Read the table of contents of a zip file, or a tar file, which may
+// be gzipped; the format is detected from the start of the file.
+func readArchiveEntries(archiveName string) ([]archiveEntry, error) {
+	file, err := os.Open(archiveName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(zipMagic))
+	count, err := io.ReadFull(file, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	magic = magic[:count]
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, zipMagic) || bytes.HasPrefix(magic, emptyZipMagic):
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return readZipEntries(file, info.Size())
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		return readTarEntries(gzipReader)
+	default:
+		return readTarEntries(file)
+	}
+}
+
+func readZipEntries(file io.ReaderAt, size int64) ([]archiveEntry, error) {
+	zipReader, err := zip.NewReader(file, size)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]archiveEntry, 0, len(zipReader.File))
+	for _, zipFile := range zipReader.File {
+		entries = append(entries, archiveEntry{
+			path: zipFile.Name,
+			mode: zipFile.Mode(),
+			size: int64(zipFile.UncompressedSize64),
+		})
+	}
+	return entries, nil
+}
+
+// Tar headers are read in turn, and the content between them is
+// skipped, not read, where the reader can seek
+func readTarEntries(reader io.Reader) ([]archiveEntry, error) {
+	var entries []archiveEntry
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch header.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeLink:
+			entries = append(entries, archiveEntry{path: header.Name,
+				hardLink: true})
+		default:
+			entries = append(entries, archiveEntry{
+				path: header.Name,
+				mode: header.FileInfo().Mode(),
+				size: header.Size,
+			})
+		}
+	}
+}
+
+// Verify the tree at root against the table of contents of an archive,
+// without reading the content of either. The archive takes the place
+// of tree1, so a path which is in it, but isn't in the tree, is
+// reported as missing; a path in the tree which isn't in it is
+// reported as extra; a path which is a different type of file is
+// reported as a different type; and a regular file whose size differs
+// from the archive's is reported as a mismatch. Regular files of the
+// same size are perfect matches, though their contents weren't
+// compared. Zip files, and tar files, gzipped or not, can be read.
+// IgnoreFiles and ExcludeDirs apply to the search for extra paths.
+func (s *ComparisonEngine) VerifyArchive(archiveName string, root string,
+	options *DifftreeOptions) error {
+
+	s.Reset()
+	if options.OutputFormat == OutputItemize {
+		return errors.New("OutputItemize cannot be used to verify an archive")
+	}
+	defer s.closeResultsDB()
+	if err := s.startReport(options); err != nil {
+		return err
+	}
+	if s.htmlReport != nil {
+		s.htmlReport.title = fmt.Sprintf("%s vs %s", root, archiveName)
+	}
+	// Directories are only checked to exist
+	s.skipDirectoryComparison = true
+
+	archiveEntries, err := readArchiveEntries(archiveName)
+	if err != nil {
+		return fmt.Errorf("Reading %s: %w", archiveName, err)
+	}
+
+	// The archive's paths, and the directories above them, which a tar
+	// file needn't list
+	root = filepath.Clean(root)
+	listed := make(map[string]bool)
+	for _, archiveEntry := range archiveEntries {
+		relPath := filepath.Clean(filepath.FromSlash(archiveEntry.path))
+		if relPath == "." {
+			continue
+		}
+		for parent := relPath; parent != "" && !listed[parent]; parent = parentRelPath(parent) {
+			listed[parent] = true
+		}
+
+		entry := treeEntry{
+			path1:   filepath.Join(root, relPath),
+			path2:   filepath.Join(root, relPath),
+			relPath: relPath,
+		}
+		if filepath.IsAbs(relPath) || relPath == ".." ||
+			strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			entry.result = kError
+			entry.err = fmt.Errorf("%s is not a path inside the tree",
+				archiveEntry.path)
+		} else {
+			entry.verifyArchiveEntry(archiveEntry, options)
+		}
+		if entry.result == kDirSameEntries {
+			continue
+		}
+		s.handleResult(&entry, options)
+	}
+
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			entry := treeEntry{path1: path, path2: path, result: kError,
+				err: fmt.Errorf("While walking onto %s: %w", path, err)}
+			if len(path) > len(root) {
+				entry.relPath = path[len(root)+1:]
+			}
+			s.handleResult(&entry, options)
+			return nil
+		}
+		if path == root {
+			return nil
+		}
+		relPath := path[len(root)+1:]
+
+		if _, has := options.IgnoreFiles[info.Name()]; has {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() && options.excludesDir(relPath) {
+			return filepath.SkipDir
+		}
+		if !listed[relPath] {
+			entry := treeEntry{path1: path, path2: path, relPath: relPath,
+				result: kExtra}
+			s.handleResult(&entry, options)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.finishReport(options)
+}
+
+// Compare an entry of the archive with the path in the tree, by type,
+// and by size for regular files. A directory which is in both is
+// kDirSameEntries, which VerifyArchive doesn't report.
+func (self *treeEntry) verifyArchiveEntry(archiveEntry archiveEntry, options *DifftreeOptions) {
+	err := options.retry(func() error {
+		var err error
+		self.info2, err = os.Lstat(self.path2)
+		return err
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			self.result = kMissing
+			return
+		}
+		self.result = kError
+		self.err = err
+		return
+	}
+	self.hasInfo2 = true
+
+	treeType := self.info2.Mode() & os.ModeType
+	if archiveEntry.hardLink {
+		if treeType != 0 {
+			self.result = kDifferentTypes
+			self.description = fmt.Sprintf(
+				"the archive has a hard link to a regular file, but the tree has a %s",
+				translateModeType(treeType))
+			return
+		}
+		self.result = kPerfectMatch
+		return
+	}
+
+	archiveType := archiveEntry.mode & os.ModeType
+	if archiveType != treeType {
+		self.result = kDifferentTypes
+		self.description = fmt.Sprintf("the archive has a %s, but the tree has a %s",
+			translateModeType(archiveType), translateModeType(treeType))
+		return
+	}
+
+	switch {
+	case archiveType == os.ModeDir:
+		self.result = kDirSameEntries
+	case archiveType == 0 && archiveEntry.size != self.info2.Size():
+		self.result = kMismatch
+		self.description = fmt.Sprintf("the archive has size %d, the file has size %d",
+			archiveEntry.size, self.info2.Size())
+	default:
+		self.result = kPerfectMatch
+	}
+}
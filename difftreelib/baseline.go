@@ -0,0 +1,147 @@
+package difftreelib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A baseline lists the differences which are expected, one per line,
+// as a path relative to the roots, optionally followed by a tab and
+// the tag of the expected result:
+//
+//	etc/motd	DTMismatch
+//	var/cache
+//
+// A path without a tag matches any difference. Blank lines, and lines
+// starting with "#", are skipped. This is the format written to
+// WriteBaseline, sorted by path, so one run's differences can be the
+// next one's baseline, and two runs' can be compared by CompareReports.
+type baseline map[string]string
+
+func readBaseline(reader io.Reader) (baseline, error) {
+	expected := make(baseline)
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var tag string
+		if i := strings.LastIndexByte(line, '\t'); i != -1 {
+			line, tag = line[:i], line[i+1:]
+		}
+		expected[line] = tag
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Reading the baseline: %w", err)
+	}
+	return expected, nil
+}
+
+// The path of an entry, as it is written in a baseline
+func baselinePath(entry *treeEntry) string {
+	if entry.relPath == "" {
+		return "."
+	}
+	return filepath.ToSlash(entry.relPath)
+}
+
+func (self baseline) expects(entry *treeEntry) bool {
+	tag, has := self[baselinePath(entry)]
+	return has && (tag == "" || tag == entry.result.String())
+}
+
+// Whether a result is a difference between the trees, and so can be
+// in a baseline
+func (self resultType) isDifference() bool {
+	switch self {
+	case kNil, kPerfectMatch, kDirSameEntries, kDirSameSubtree, kIgnored, kNotSampled,
+		kDirNotCompared, kBaselined, kNotExecutable, kAcceptedPerms, kDirMtimePrecision:
+		return false
+	default:
+		return true
+	}
+}
+
+// Keep a difference for WriteBaseline, and, if it is in the baseline
+// from options.Baseline, change its result to kBaselined.
+func (s *ComparisonEngine) applyBaseline(entry *treeEntry, options *DifftreeOptions) {
+	if !entry.result.isDifference() {
+		return
+	}
+
+	if options.WriteBaseline != nil {
+		s.baselineLines = append(s.baselineLines,
+			fmt.Sprintf("%s\t%s\n", baselinePath(entry), entry.result))
+	}
+
+	if s.baseline != nil && s.baseline.expects(entry) {
+		entry.description = fmt.Sprintf("expected %s", entry.result)
+		entry.result = kBaselined
+	}
+}
+
+// Write the differences kept for WriteBaseline, sorted, so that the
+// same differences always give the same file.
+func (s *ComparisonEngine) writeBaseline(writer io.Writer) error {
+	sort.Strings(s.baselineLines)
+	for _, line := range s.baselineLines {
+		if _, err := io.WriteString(writer, line); err != nil {
+			return fmt.Errorf("Writing the baseline: %w", err)
+		}
+	}
+	return nil
+}
+
+// Compare two reports of differences, as written to WriteBaseline by
+// two runs, and write what changed between them, sorted by path. Each
+// difference only in newReport is written as "+ <path>\t<tag>", and
+// each one only in oldReport, that is, resolved, as "- <path>\t<tag>".
+// A path whose result changed has both.
+func CompareReports(oldReport io.Reader, newReport io.Reader, writer io.Writer) error {
+	old, err := readBaseline(oldReport)
+	if err != nil {
+		return err
+	}
+	current, err := readBaseline(newReport)
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(old)+len(current))
+	for path := range old {
+		paths = append(paths, path)
+	}
+	for path := range current {
+		if _, has := old[path]; !has {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		oldTag, inOld := old[path]
+		newTag, inNew := current[path]
+		if inOld && inNew && oldTag == newTag {
+			continue
+		}
+		if inOld {
+			_, err = fmt.Fprintf(writer, "- %s\t%s\n", path, oldTag)
+			if err != nil {
+				return err
+			}
+		}
+		if inNew {
+			_, err = fmt.Fprintf(writer, "+ %s\t%s\n", path, newTag)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
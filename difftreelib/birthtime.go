@@ -0,0 +1,30 @@
+package difftreelib
+
+import (
+	"fmt"
+	"time"
+)
+
+// Returns true if the birthtimes differ. If either birthtime is not
+// available, they are not compared.
+func (self *treeEntry) compareBirthtimes(options *DifftreeOptions) (metadataDiff, bool) {
+	birth1, ok1 := getBirthtime(self.path1, self.info1)
+	birth2, ok2 := getBirthtime(self.path2, self.info2)
+	if !ok1 || !ok2 {
+		return metadataDiff{}, false
+	}
+
+	diff := birth1.Sub(birth2)
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff <= options.BirthtimeTolerance {
+		return metadataDiff{}, false
+	}
+
+	return metadataDiff{
+		result: kDifferentBirthtime,
+		description: fmt.Sprintf("file1 was created %s, file2 was created %s",
+			birth1.Format(time.RFC3339Nano), birth2.Format(time.RFC3339Nano)),
+	}, true
+}
@@ -0,0 +1,18 @@
+//go:build darwin || freebsd || netbsd
+// +build darwin freebsd netbsd
+
+package difftreelib
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func getBirthtime(path string, info os.FileInfo) (time.Time, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(stat.Birthtimespec.Unix()), true
+}
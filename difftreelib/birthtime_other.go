@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+// +build !linux,!darwin,!freebsd,!netbsd
+
+package difftreelib
+
+import (
+	"os"
+	"time"
+)
+
+// The birthtime is not available on this platform
+func getBirthtime(path string, info os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
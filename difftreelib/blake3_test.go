@@ -0,0 +1,195 @@
+package difftreelib
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lukechampine.com/blake3"
+)
+
+var blake3BenchSize = flag.Int64("blake3-bench-size", 1<<30,
+	"The size of the file for BenchmarkBLAKE3")
+
+// Write a file of random bytes
+func writeRandomFile(t testing.TB, path string, size int64) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := io.CopyN(f, rand.New(rand.NewSource(1)), size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func hashSingleStreamBLAKE3(t testing.TB, path string) []byte {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	hasher := blake3.New(blake3DigestSize, nil)
+	if _, err := io.Copy(hasher, f); err != nil {
+		t.Fatal(err)
+	}
+	return hasher.Sum(nil)
+}
+
+func TestBLAKE3(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	tests := []struct {
+		name      string
+		size      int64
+		segmented bool
+	}{
+		{"empty", 0, false},
+		{"small", 1000, false},
+		{"below the threshold", parallelHashThreshold - 1, false},
+		{"at the threshold", parallelHashThreshold, true},
+		{"part of a segment over", parallelHashThreshold + 1000, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, "file")
+			writeRandomFile(t, path, test.size)
+			hash, err := getFileHashBLAKE3(path, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Small files hash as b3sum would
+			single := hashSingleStreamBLAKE3(t, path)
+			if got := bytes.Equal(hash, single); got == test.segmented {
+				t.Errorf("hash is the single stream's: %v, want %v", got, !test.segmented)
+			}
+
+			again, err := getFileHashBLAKE3(path, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(hash, again) {
+				t.Errorf("hashed as %x, then %x", hash, again)
+			}
+			if test.size == 0 {
+				return
+			}
+
+			// The last byte changes the hash
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data[len(data)-1] ^= 1
+			if err := ioutil.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			changed, err := getFileHashBLAKE3(path, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(hash, changed) {
+				t.Errorf("changing the last byte didn't change the hash")
+			}
+		})
+	}
+}
+
+// A file whose contents are the digests of a large file's segments
+// doesn't have the large file's hash
+func TestSegmentedBLAKE3IsDomainSeparated(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	large := filepath.Join(dir, "large")
+	writeRandomFile(t, large, parallelHashThreshold)
+	data, err := ioutil.ReadFile(large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var digests []byte
+	for offset := 0; offset < len(data); offset += parallelHashSegmentSize {
+		digest := blake3.Sum256(data[offset : offset+parallelHashSegmentSize])
+		digests = append(digests, digest[:]...)
+	}
+	small := filepath.Join(dir, "small")
+	if err := ioutil.WriteFile(small, digests, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	largeHash, err := getFileHashBLAKE3(large, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallHash, err := getFileHashBLAKE3(small, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(largeHash, smallHash) {
+		t.Errorf("both files hash to %x", largeHash)
+	}
+	if unkeyed := blake3.Sum256(digests); bytes.Equal(largeHash, unkeyed[:]) {
+		t.Errorf("the segments' digests are hashed without a key")
+	}
+}
+
+func TestFileHashName(t *testing.T) {
+	tests := []struct {
+		algorithm    string
+		recordHashes bool
+		size         int64
+		want         string
+	}{
+		{"", false, parallelHashThreshold, "SHA1"},
+		{HashSHA1, false, parallelHashThreshold, "SHA1"},
+		{HashBLAKE3, false, parallelHashThreshold - 1, "BLAKE3"},
+		{HashBLAKE3, false, parallelHashThreshold, "segmented BLAKE3"},
+		{HashBLAKE3, true, parallelHashThreshold, "BLAKE3"},
+	}
+	for _, test := range tests {
+		options := DifftreeOptions{HashAlgorithm: test.algorithm,
+			RecordAllHashes: test.recordHashes}
+		if got := options.fileHashName(test.size); got != test.want {
+			t.Errorf("fileHashName(%d) with %q = %q, want %q", test.size,
+				test.algorithm, got, test.want)
+		}
+	}
+}
+
+// Compare the segmented hash of a large file with a single stream.
+// The file is read from the page cache, if it fits, so this measures
+// the hashing; set -blake3-bench-size for more or less than 1GiB.
+func BenchmarkBLAKE3(b *testing.B) {
+	dir, cleanup := tempDir(b)
+	defer cleanup()
+	path := filepath.Join(dir, "file")
+	writeRandomFile(b, path, *blake3BenchSize)
+
+	b.Run("single stream", func(b *testing.B) {
+		b.SetBytes(*blake3BenchSize)
+		for i := 0; i < b.N; i++ {
+			hashSingleStreamBLAKE3(b, path)
+		}
+	})
+	b.Run("segments", func(b *testing.B) {
+		b.SetBytes(*blake3BenchSize)
+		f, err := os.Open(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer f.Close()
+		for i := 0; i < b.N; i++ {
+			if _, err := hashSegmentsBLAKE3(f, *blake3BenchSize); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package difftreelib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	defaultBlockSize = 1 << 20
+
+	// List at most this many differing offsets in the description
+	maxListedBlocks = 20
+)
+
+func (self *DifftreeOptions) blockSize() int64 {
+	if self.BlockSize > 0 {
+		return self.BlockSize
+	}
+	return defaultBlockSize
+}
+
+// io.ReadFull's errors for reaching the end of the file
+func isEndOfFile(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+// Compare two files of the same size block by block, and list the
+// offsets of the blocks which differ.
+func (self *treeEntry) compareBlocks(options *DifftreeOptions) {
+	f1, err := os.Open(self.path1)
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return
+	}
+	defer f1.Close()
+
+	f2, err := os.Open(self.path2)
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return
+	}
+	defer f2.Close()
+
+	blockSize := options.blockSize()
+	buf1 := make([]byte, blockSize)
+	buf2 := make([]byte, blockSize)
+
+	var offsets []string
+	numDiffering := 0
+	for offset := int64(0); ; offset += blockSize {
+		n1, err1 := io.ReadFull(f1, buf1)
+		n2, err2 := io.ReadFull(f2, buf2)
+		if n1 == 0 && n2 == 0 {
+			break
+		}
+		if !bytes.Equal(buf1[:n1], buf2[:n2]) {
+			numDiffering++
+			if len(offsets) < maxListedBlocks {
+				offsets = append(offsets, fmt.Sprintf("%d", offset))
+			}
+		}
+		for _, err := range []error{err1, err2} {
+			if err != nil && !isEndOfFile(err) {
+				self.result = kError
+				self.err = err
+				return
+			}
+		}
+		if err1 != nil || err2 != nil {
+			break
+		}
+	}
+
+	if numDiffering == 0 {
+		self.result = kPerfectMatch
+		return
+	}
+
+	self.result = kMismatch
+	self.description = fmt.Sprintf("%d blocks of %d bytes differ, at offsets %s",
+		numDiffering, blockSize, strings.Join(offsets, ", "))
+	if numDiffering > len(offsets) {
+		self.description += ", ..."
+	}
+}
@@ -0,0 +1,104 @@
+package difftreelib
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// A range of byte offsets in a file, from Start up to, but not
+// including, End
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// Writes to a hash, with the bytes in the ignored ranges zeroed
+type maskingWriter struct {
+	hasher hash.Hash
+	ranges []ByteRange
+	offset int64
+}
+
+func (self *maskingWriter) Write(data []byte) (int, error) {
+	start := self.offset
+	end := start + int64(len(data))
+	var masked []byte
+	for _, r := range self.ranges {
+		if r.End <= start || r.Start >= end {
+			continue
+		}
+		if masked == nil {
+			masked = make([]byte, len(data))
+			copy(masked, data)
+		}
+		from := r.Start - start
+		if from < 0 {
+			from = 0
+		}
+		to := r.End - start
+		if to > int64(len(data)) {
+			to = int64(len(data))
+		}
+		for i := from; i < to; i++ {
+			masked[i] = 0
+		}
+	}
+	if masked != nil {
+		data = masked
+	}
+	self.offset = end
+	return self.hasher.Write(data)
+}
+
+func hashFileIgnoringRanges(filename string, options *DifftreeOptions) ([]byte, error) {
+	var hash []byte
+	err := options.retry(func() error {
+		f, err := os.Open(filename)
+		if err != nil {
+			return fmt.Errorf("Opening %s for hashing: %w", filename, err)
+		}
+		defer f.Close()
+
+		writer := &maskingWriter{
+			hasher: newHasher(options),
+			ranges: options.IgnoreByteRanges,
+		}
+		if _, err = io.Copy(writer, f); err != nil {
+			return fmt.Errorf("Reading %s for hashing: %w", filename, err)
+		}
+		hash = writer.hasher.Sum(nil)
+		return nil
+	})
+	return hash, err
+}
+
+// Compare two files of the same size by their hashes, with the bytes
+// in IgnoreByteRanges left out
+func (self *treeEntry) compareIgnoringRanges(options *DifftreeOptions) {
+	hash1, err := hashFileIgnoringRanges(self.path1, options)
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return
+	}
+	hash2, err := hashFileIgnoringRanges(self.path2, options)
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return
+	}
+	self.bytesRead += self.info1.Size() + self.info2.Size()
+
+	if cmpByteSlices(hash1, hash2) {
+		self.result = kPerfectMatch
+		return
+	}
+	self.result = kMismatch
+	self.description = fmt.Sprintf(
+		"outside of the ignored byte ranges, file1 has %s %s, file2 has %s %s",
+		options.hashName(), hex.EncodeToString(hash1),
+		options.hashName(), hex.EncodeToString(hash2))
+}
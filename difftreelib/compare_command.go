@@ -0,0 +1,91 @@
+package difftreelib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// The default limit on how long one CompareCommand may run
+const defaultCompareCommandTimeout = time.Minute
+
+func (self *DifftreeOptions) compareCommandTimeout() time.Duration {
+	if self.CompareCommandTimeout > 0 {
+		return self.CompareCommandTimeout
+	}
+	return defaultCompareCommandTimeout
+}
+
+// A CompareCommand of only spaces would run the file from tree1, with
+// the file from tree2 as its argument
+func (self *DifftreeOptions) checkCompareCommand() error {
+	if self.CompareCommand != "" && len(strings.Fields(self.CompareCommand)) == 0 {
+		return errors.New("CompareCommand has no command to run")
+	}
+	return nil
+}
+
+// Run CompareCommand on the two files. Exit status 0 is a match, and
+// any other is a mismatch, described by what the command wrote to
+// stderr.
+func (self *treeEntry) runCompareCommand(options *DifftreeOptions) {
+	args := strings.Fields(options.CompareCommand)
+	args = append(args, self.path1, self.path2)
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		options.compareCommandTimeout())
+	defer cancel()
+
+	// The command's stderr is read through our own pipe, as anything
+	// it started could keep the pipe open after it's killed, and
+	// exec would wait for that.
+	stderrReader, stderrWriter, err := os.Pipe()
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return
+	}
+	defer stderrReader.Close()
+	var stderr bytes.Buffer
+	stderrRead := make(chan struct{})
+	go func() {
+		io.Copy(&stderr, stderrReader)
+		close(stderrRead)
+	}()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stderr = stderrWriter
+	err = cmd.Run()
+	stderrWriter.Close()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		self.result = kError
+		self.err = fmt.Errorf("%s timed out after %s", args[0],
+			options.compareCommandTimeout())
+		return
+	}
+	if err == nil {
+		self.result = kPerfectMatch
+		return
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		self.result = kError
+		self.err = err
+		return
+	}
+	<-stderrRead
+
+	self.result = kMismatch
+	self.description = fmt.Sprintf("%s exited with status %d",
+		args[0], exitErr.ExitCode())
+	if message := strings.TrimSpace(stderr.String()); message != "" {
+		self.description += ": " + message
+	}
+}
@@ -0,0 +1,25 @@
+package difftreelib
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBlankCompareCommand(t *testing.T) {
+	path1, path2, cleanup := makeTrees(t,
+		testTree{"file": "1"},
+		testTree{"file": "2"})
+	defer cleanup()
+
+	for _, command := range []string{" ", "\t \n"} {
+		options := DifftreeOptions{CompareCommand: command, Quiet: true}
+		var engine ComparisonEngine
+		if err := engine.Compare(path1, path2, &options); err == nil {
+			t.Errorf("Compare with CompareCommand %q succeeded", command)
+		}
+		if _, err := CompareFiles(filepath.Join(path1, "file"), filepath.Join(path2, "file"),
+			&options); err == nil {
+			t.Errorf("CompareFiles with CompareCommand %q succeeded", command)
+		}
+	}
+}
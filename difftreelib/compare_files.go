@@ -0,0 +1,33 @@
+package difftreelib
+
+import (
+	"os"
+)
+
+// Compare two individual paths, with the same checks that Compare
+// applies to each path in a tree. If they are directories, only their
+// entries are compared; they are not descended into. The returned
+// error is the one which stopped the comparison, if any; it is also
+// in the Result.
+func CompareFiles(path1 string, path2 string, options *DifftreeOptions) (Result, error) {
+	if err := options.checkCompareCommand(); err != nil {
+		return Result{Path: path1, Result: kError.String(), Err: err}, err
+	}
+	info1, err := os.Lstat(path1)
+	if err != nil {
+		return Result{Path: path1, Result: kError.String(), Err: err}, err
+	}
+
+	entry := treeEntry{
+		path1: path1,
+		path2: path2,
+		info1: info1,
+	}
+	entry.comparePaths(options)
+
+	result := entry.toResult(path1)
+	if entry.result == kError {
+		return result, entry.err
+	}
+	return result, nil
+}
@@ -0,0 +1,119 @@
+package difftreelib
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// For ContentIndexMode, the relative paths of the regular files in a
+// tree, by the hashes of their contents
+type contentIndex map[string][]string
+
+// Hash every regular file in the tree which the walk wouldn't skip
+func buildContentIndex(root string, options *DifftreeOptions) (contentIndex, error) {
+	root = filepath.Clean(root)
+	index := make(contentIndex)
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		relPath := path[len(root)+1:]
+
+		if _, has := options.IgnoreFiles[info.Name()]; has ||
+			(options.IgnoreDotfiles && isDotfile(info.Name())) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if options.excludesDir(relPath) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		hash, err := getFileHash(path, options)
+		if err != nil {
+			return err
+		}
+		key := string(hash)
+		index[key] = append(index[key], relPath)
+		return nil
+	})
+	return index, err
+}
+
+func (s *ComparisonEngine) startContentIndexes(path1 string, path2 string,
+	options *DifftreeOptions) error {
+
+	var err error
+	s.contentIndex1, err = buildContentIndex(path1, options)
+	if err != nil {
+		return fmt.Errorf("Indexing the contents of %s: %w", path1, err)
+	}
+	s.contentIndex2, err = buildContentIndex(path2, options)
+	if err != nil {
+		return fmt.Errorf("Indexing the contents of %s: %w", path2, err)
+	}
+	return nil
+}
+
+// The hashes, in hex, of the contents in one index but not the other,
+// sorted, with their paths, each sorted
+func contentOnlyIn(index contentIndex, other contentIndex) ([]string, map[string][]string) {
+	var hashes []string
+	paths := make(map[string][]string)
+	for key, relPaths := range index {
+		if _, has := other[key]; has {
+			continue
+		}
+		hexHash := hex.EncodeToString([]byte(key))
+		hashes = append(hashes, hexHash)
+		sort.Strings(relPaths)
+		paths[hexHash] = relPaths
+	}
+	sort.Strings(hashes)
+	return hashes, paths
+}
+
+// Count the contents which are only in one tree, wherever they are,
+// and print them, unless the results aren't printed. Each path with
+// contents only in tree1 is on a line of its own, as "-", the hash in
+// hex, a tab, and the path relative to the root, like a diff; those
+// only in tree2 are the same, but with "+".
+func (s *ComparisonEngine) reportContentIndex(options *DifftreeOptions) {
+	hashes1, paths1 := contentOnlyIn(s.contentIndex1, s.contentIndex2)
+	hashes2, paths2 := contentOnlyIn(s.contentIndex2, s.contentIndex1)
+	s.stats.ContentOnlyInTree1 = len(hashes1)
+	s.stats.ContentOnlyInTree2 = len(hashes2)
+
+	if options.Quiet || s.events != nil || s.htmlReport != nil || s.itemize {
+		return
+	}
+	if len(hashes1) == 0 && len(hashes2) == 0 {
+		return
+	}
+	fmt.Print("CONTENT INDEX DIFFERENCES\n========================================\n")
+	for _, hexHash := range hashes1 {
+		for _, relPath := range paths1[hexHash] {
+			fmt.Printf("-%s\t%s\n", hexHash, relPath)
+		}
+	}
+	for _, hexHash := range hashes2 {
+		for _, relPath := range paths2[hexHash] {
+			fmt.Printf("+%s\t%s\n", hexHash, relPath)
+		}
+	}
+	fmt.Print("\n")
+}
@@ -0,0 +1,53 @@
+package difftreelib
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// http.DetectContentType looks at no more than this many bytes
+const sniffLen = 512
+
+// Guess the MIME type of a file from its first bytes
+func detectContentType(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("Reading %s: %w", filename, err)
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// Compare the MIME types of two regular files. This returns true if
+// it decided the result, because the types differ or couldn't be
+// found; otherwise, the contents still need to be compared.
+func (self *treeEntry) compareContentTypes() bool {
+	type1, err := detectContentType(self.path1)
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return true
+	}
+	type2, err := detectContentType(self.path2)
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return true
+	}
+
+	if type1 != type2 {
+		self.result = kDifferentContentType
+		self.description = fmt.Sprintf("file1 is %s, but file2 is %s",
+			type1, type2)
+		return true
+	}
+	return false
+}
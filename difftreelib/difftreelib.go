@@ -2,20 +2,209 @@ package difftreelib
 
 import (
 	"fmt"
+	"time"
 )
 
 type ComparisonEngine struct {
-	countPerfectMatch   int
-	countError          int
-	countDifferentTypes int
-	countDifferentPerms int
-	countMismatch       int
-	countMissing        int
-	countDirSame        int
-	countDirDifferent   int
-	countIgnoredByUser  int
+	// First, for the 64-bit alignment of its atomic counters
+	comparison
+
+	// For Stop. Reset doesn't clear it, as Stop may be called from
+	// another goroutine while it does; a Stop which comes before
+	// Compare stops the comparison as soon as it starts.
+	stopRequested int32
+}
+
+// The state of one comparison, which Reset clears
+type comparison struct {
+	// First, for 64-bit alignment of its atomic counter
+	timing stageTiming
+
+	stats Stats
+
+	// How many results were printed, and how many were
+	// not because of MaxReport
+	numReported   int
+	numUnreported int
+
+	events    *eventWriter
+	resultsDB *resultsDBWriter
+
+	// The differences, for DifftreeOptions.TreeView
+	treeView *treeViewNode
+
+	// The differences, for OutputHTML
+	htmlReport *htmlReport
+
+	// With OutputItemize, only the itemized lines are printed
+	itemize bool
+
+	// The directory differences, for DifftreeOptions.GroupDirDiffs
+	dirDiffs []*treeEntry
+
+	// The expected differences, from DifftreeOptions.Baseline
+	baseline baseline
+
+	// The differences to write to DifftreeOptions.WriteBaseline
+	baselineLines []string
+
+	// The matched files to write to DifftreeOptions.WriteMatched
+	matchedLines []string
+
+	// For DetectRenames
+	missingFiles []*renameCandidate
+	extraFiles   []*renameCandidate
+
+	// Set after the walk, when the missing files are reported, so that
+	// they aren't held back again
+	reportingRenames bool
+
+	// The summary then has no directory counts
+	skipDirectoryComparison bool
 
 	path1RootLen int
+
+	// For DifftreeOptions.Profile
+	profile pathProfile
+
+	// For DifftreeOptions.ReportDuplicates
+	duplicates *duplicateFinder
+
+	// For DifftreeOptions.DirectoryMerkle
+	dirHashes1 dirHashes
+	dirHashes2 dirHashes
+
+	// For DifftreeOptions.IgnoreForExit, the results to leave out of
+	// ExitSeverity, and their counts
+	exitIgnores map[resultType]bool
+	exitIgnored Stats
+
+	// For DifftreeOptions.ContentIndexMode
+	contentIndex1 contentIndex
+	contentIndex2 contentIndex
+
+	// Why the readers stopped early. These flags are accessed
+	// atomically, as with PartitionTopLevel there are several readers.
+
+	// For DifftreeOptions.Deadline
+	deadline       time.Time
+	deadlinePassed int32
+
+	// For DifftreeOptions.MaxTotalBytesRead
+	readBudget          *readBudget
+	readBudgetExhausted int32
+
+	// For Stop
+	interrupted int32
+
+	// For StopOnFirstDiff; foundDiff is only used by the reporter
+	stopForDiff   int32
+	stoppedAtDiff int32
+	foundDiff     bool
+}
+
+// The counts of each kind of result. When marshalled to JSON,
+// the keys are the ones given in the field tags.
+type Stats struct {
+	PerfectMatch             int `json:"perfect_matches"`
+	Mismatch                 int `json:"mismatches"`
+	Truncated                int `json:"truncated"`
+	NormalizedMatch          int `json:"normalized_matches"`
+	Missing                  int `json:"missing"`
+	Extra                    int `json:"extra"`
+	Moved                    int `json:"moved"`
+	CaseRenamed              int `json:"case_renamed"`
+	DifferentTypes           int `json:"different_types"`
+	DifferentContentTypes    int `json:"different_content_types"`
+	DifferentPerms           int `json:"different_perms"`
+	AcceptedPerms            int `json:"accepted_perms"`
+	DifferentOwners          int `json:"different_owners"`
+	DifferentXattrs          int `json:"different_xattrs"`
+	DifferentBirthtime       int `json:"different_birthtimes"`
+	DifferentProjectIDs      int `json:"different_project_ids"`
+	DifferentGenerations     int `json:"different_inode_generations"`
+	MetadataDiffers          int `json:"metadata_differs"`
+	DifferentTargets         int `json:"different_symlink_targets"`
+	SameResolvedContent      int `json:"same_resolved_content"`
+	DifferentResolvedContent int `json:"different_resolved_content"`
+	EscapingSymlinks         int `json:"escaping_symlinks"`
+	SuspiciousPerms          int `json:"suspicious_perms"`
+	IgnoredByUser            int `json:"ignored"`
+	Baselined                int `json:"baselined"`
+	Error                    int `json:"errors"`
+	DirSame                  int `json:"dirs_same_entries"`
+	DirSameSubtree           int `json:"dirs_same_subtree,omitempty"`
+	DirDifferent             int `json:"dirs_different_entries"`
+	DirDifferentDotfiles     int `json:"dirs_different_dotfiles"`
+	DirDifferentMtime        int `json:"dirs_different_mtimes"`
+	DirMtimePrecision        int `json:"dirs_mtime_precision_only"`
+	DirDifferentAttrs        int `json:"dirs_different_entry_attrs"`
+
+	// Whether DifftreeOptions.Deadline passed, MaxTotalBytesRead was
+	// reached, or Stop was called, before all of the paths were
+	// compared, and which
+	Incomplete       bool   `json:"incomplete,omitempty"`
+	IncompleteReason string `json:"incomplete_reason,omitempty"`
+
+	// Only with DifftreeOptions.ApplyMissing or ApplyPerms: the
+	// fixes made to tree2, those which failed, and, with ApplyDryRun,
+	// those which would have been made
+	Applied     int `json:"applied,omitempty"`
+	ApplyFailed int `json:"apply_failed,omitempty"`
+	WouldApply  int `json:"would_apply,omitempty"`
+
+	// Only for sampled runs: the SampleRate, how many files were
+	// compared, and how many were skipped
+	SampleRate float64 `json:"sample_rate,omitempty"`
+	Sampled    int     `json:"sampled,omitempty"`
+	NotSampled int     `json:"not_sampled,omitempty"`
+
+	// Only with DifftreeOptions.SkipMatched: the files taken as still
+	// matching, without reading them
+	SkippedMatched int `json:"skipped_matched,omitempty"`
+
+	// Only with DifftreeOptions.ReportDuplicates: the number of groups
+	// of files with the same contents, within tree1 or within tree2
+	DuplicateGroups int `json:"duplicate_groups,omitempty"`
+
+	// Only with DifftreeOptions.ContentIndexMode: the number of
+	// distinct contents of files in each tree, but not in the other
+	ContentOnlyInTree1 int `json:"content_only_in_tree1,omitempty"`
+	ContentOnlyInTree2 int `json:"content_only_in_tree2,omitempty"`
+
+	// Only with DifftreeOptions.HashSampleRate: the rate, and how many
+	// same-size files were hashed, and how many were assumed to match
+	HashSampleRate float64 `json:"hash_sample_rate,omitempty"`
+	HashVerified   int     `json:"hash_verified,omitempty"`
+	HashAssumed    int     `json:"hash_assumed,omitempty"`
+}
+
+// The result of comparing one path in the two trees. Result is the tag
+// used for it in the report, like "DTMismatch".
+type Result struct {
+	Path        string
+	Result      string
+	Description string
+	Err         error
+}
+
+// Whether the two paths were found to be the same
+func (self Result) Same() bool {
+	switch self.Result {
+	case kPerfectMatch.String(), kDirSameEntries.String(), kDirSameSubtree.String():
+		return true
+	default:
+		return false
+	}
+}
+
+// Clear the counts, and all other state, from a previous comparison
+func (s *ComparisonEngine) Reset() {
+	s.comparison = comparison{}
+}
+
+func (s *ComparisonEngine) Stats() Stats {
+	return s.stats
 }
 
 func (s *ComparisonEngine) Summarize() {
@@ -24,22 +213,147 @@ func (s *ComparisonEngine) Summarize() {
 ========================================
 # Perfect Matches:              %8d
 # Mismatches:                   %8d DTMismatch
+# Truncated copies:             %8d DTTruncated
+# Matches after normalization:  %8d DTNormalized
 # Missing:                      %8d DTMissing
+# Extra:                        %8d DTExtra
+# Moved:                        %8d DTMoved
+# Renamed in case:              %8d DTCaseRename
 # Different Types:              %8d DTDiffTypes
+# Different Content Types:      %8d DTDiffContentType
 # Different Perms:              %8d DTDiffPerms
+# Allowed perm changes:         %8d DTAcceptedPerms
+# Different Owners:             %8d DTDiffOwner
+# Different Xattrs:             %8d DTDiffXattrs
+# Different Birthtimes:         %8d DTDiffBirthtime
+# Different Project IDs:        %8d DTDiffProjectID
+# Different Inode Generations:  %8d DTDiffGeneration
+# Different metadata only:      %8d DTDiffMetadata
+# Different Symlink Targets:    %8d DTDiffTarget
+# Symlinks, same resolved file: %8d DTSameResolved
+# Symlinks, diff resolved file: %8d DTDiffResolved
+# Symlinks escaping the tree:   %8d DTEscapes
+# Suspicious permissions:       %8d DTSuspiciousPerms
 # Ignored (by user):            %8d DTIgnored
+# Expected (in the baseline):   %8d DTBaselined
 # Errors while reading:         %8d DTError
+`,
+		s.stats.PerfectMatch,
+		s.stats.Mismatch,
+		s.stats.Truncated,
+		s.stats.NormalizedMatch,
+		s.stats.Missing,
+		s.stats.Extra,
+		s.stats.Moved,
+		s.stats.CaseRenamed,
+		s.stats.DifferentTypes,
+		s.stats.DifferentContentTypes,
+		s.stats.DifferentPerms,
+		s.stats.AcceptedPerms,
+		s.stats.DifferentOwners,
+		s.stats.DifferentXattrs,
+		s.stats.DifferentBirthtime,
+		s.stats.DifferentProjectIDs,
+		s.stats.DifferentGenerations,
+		s.stats.MetadataDiffers,
+		s.stats.DifferentTargets,
+		s.stats.SameResolvedContent,
+		s.stats.DifferentResolvedContent,
+		s.stats.EscapingSymlinks,
+		s.stats.SuspiciousPerms,
+		s.stats.IgnoredByUser,
+		s.stats.Baselined,
+		s.stats.Error)
 
+	if !s.skipDirectoryComparison {
+		if s.stats.DirSameSubtree > 0 {
+			fmt.Printf(`
+# Dirs with same subtrees:      %8d DTSameSubtree (their contents are not counted)
+`,
+				s.stats.DirSameSubtree)
+		}
+		fmt.Printf(`
 # Dirs with same entries:       %8d
 # Dirs with different entries:  %8d DTDiffEntries
+# Dirs differing in dotfiles:   %8d DTDiffDotfiles
+# Dirs with different mtimes:   %8d DTDiffDirMtime
+# Dirs, mtime precision only:   %8d DTMtimePrecision
+# Dirs with changed entries:    %8d DTDiffEntryAttrs
+`,
+			s.stats.DirSame,
+			s.stats.DirDifferent,
+			s.stats.DirDifferentDotfiles,
+			s.stats.DirDifferentMtime,
+			s.stats.DirMtimePrecision,
+			s.stats.DirDifferentAttrs)
+	}
+
+	if s.stats.SampleRate > 0 {
+		fmt.Printf(`
+APPROXIMATE: this was a sampled run, at a rate of %g
+# Files compared (sample size): %8d
+# Files not sampled:            %8d DTNotSampled
+`,
+			s.stats.SampleRate,
+			s.stats.Sampled,
+			s.stats.NotSampled)
+	}
+
+	if s.stats.SkippedMatched > 0 {
+		fmt.Printf(`
+# Unchanged since they matched: %8d (not read again)
+`,
+			s.stats.SkippedMatched)
+	}
+
+	if s.stats.DuplicateGroups > 0 {
+		fmt.Printf(`
+# Groups of duplicate files:    %8d
+`,
+			s.stats.DuplicateGroups)
+	}
+
+	if s.stats.ContentOnlyInTree1 > 0 || s.stats.ContentOnlyInTree2 > 0 {
+		fmt.Printf(`
+# Contents only in tree1:       %8d
+# Contents only in tree2:       %8d
+`,
+			s.stats.ContentOnlyInTree1,
+			s.stats.ContentOnlyInTree2)
+	}
+
+	if s.stats.HashSampleRate > 0 {
+		fmt.Printf(`
+SPOT-CHECKED: same-size files were hashed at a rate of %g
+# Files verified by hash:       %8d
+# Files assumed to match:       %8d
+`,
+			s.stats.HashSampleRate,
+			s.stats.HashVerified,
+			s.stats.HashAssumed)
+	}
+
+	if s.stats.Applied > 0 || s.stats.ApplyFailed > 0 {
+		fmt.Printf(`
+APPLIED: the second dir was changed to match the first
+# Fixes applied:                %8d
+# Fixes which failed:           %8d
+`,
+			s.stats.Applied,
+			s.stats.ApplyFailed)
+	}
+	if s.stats.WouldApply > 0 {
+		fmt.Printf(`
+DRY RUN: the second dir was not changed
+# Fixes which would be applied: %8d
+`,
+			s.stats.WouldApply)
+	}
+
+	if s.stats.Incomplete {
+		fmt.Printf(`
+INCOMPLETE: %s before all of the paths were compared
 `,
-		s.countPerfectMatch,
-		s.countMismatch,
-		s.countMissing,
-		s.countDifferentTypes,
-		s.countDifferentPerms,
-		s.countIgnoredByUser,
-		s.countError,
-		s.countDirSame,
-		s.countDirDifferent)
+			s.stats.IncompleteReason)
+	}
 }
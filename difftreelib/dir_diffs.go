@@ -0,0 +1,47 @@
+package difftreelib
+
+import (
+	"fmt"
+	"sort"
+)
+
+// The results about the structure of directories, which GroupDirDiffs
+// holds back until the end
+func isDirStructureDiff(result resultType) bool {
+	switch result {
+	case kDirDifferentEntries, kDirDifferentDotfiles, kDirDifferentMtime,
+		kDirDifferentAttrs:
+		return true
+	}
+	return false
+}
+
+// Keep what is needed to print the result, as the entry is recycled
+func (s *ComparisonEngine) holdDirDiff(entry *treeEntry) {
+	s.dirDiffs = append(s.dirDiffs, &treeEntry{
+		path1:       entry.path1,
+		path2:       entry.path2,
+		relPath:     entry.relPath,
+		result:      entry.result,
+		description: entry.description,
+	})
+}
+
+// Print the directory differences held back by GroupDirDiffs, in order
+// of their paths, as a section after all of the other results
+func (s *ComparisonEngine) printDirDiffs(options *DifftreeOptions) {
+	if len(s.dirDiffs) == 0 {
+		return
+	}
+	sort.Slice(s.dirDiffs, func(i, j int) bool {
+		return s.relativePath(s.dirDiffs[i]) < s.relativePath(s.dirDiffs[j])
+	})
+
+	if options.OutputFormat == "" || options.OutputFormat == OutputText {
+		fmt.Printf("DIRECTORY STRUCTURE\n" +
+			"========================================\n")
+	}
+	for _, entry := range s.dirDiffs {
+		s.printEntry(entry, options)
+	}
+}
@@ -0,0 +1,72 @@
+package difftreelib
+
+import (
+	"fmt"
+	"time"
+)
+
+// Returns true if the modification times of the two directories differ
+func (self *treeEntry) compareDirMtimes(options *DifftreeOptions) (metadataDiff, bool) {
+	mtime1 := self.info1.ModTime()
+	mtime2 := self.info2.ModTime()
+
+	diff := mtime1.Sub(mtime2)
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff <= options.DirMtimeTolerance {
+		return metadataDiff{}, false
+	}
+
+	if options.MtimePrecisionOnly {
+		if precision, same := sameAtCoarserPrecision(mtime1, mtime2); same {
+			// Reported only if nothing else differs
+			self.mtimePrecision = fmt.Sprintf(
+				"dir1 was modified %s, dir2 was modified %s, the same to within %s",
+				mtime1.Format(time.RFC3339Nano), mtime2.Format(time.RFC3339Nano),
+				precision)
+			return metadataDiff{}, false
+		}
+	}
+
+	return metadataDiff{
+		result: kDirDifferentMtime,
+		description: fmt.Sprintf("dir1 was modified %s, dir2 was modified %s",
+			mtime1.Format(time.RFC3339Nano), mtime2.Format(time.RFC3339Nano)),
+	}, true
+}
+
+// The precisions with which filesystems store times, coarsest first:
+// whole seconds, and sub-second units down to NTFS's 100ns. FAT's two
+// seconds isn't judged, as half of all whole seconds would look like it.
+var timePrecisions = []time.Duration{
+	time.Second,
+	time.Millisecond,
+	time.Microsecond,
+	100 * time.Nanosecond,
+}
+
+// The coarsest precision which the time could have been stored with,
+// judging by its trailing zeros
+func timePrecision(t time.Time) time.Duration {
+	nanos := t.UnixNano()
+	for _, precision := range timePrecisions {
+		if nanos%int64(precision) == 0 {
+			return precision
+		}
+	}
+	return time.Nanosecond
+}
+
+// Whether two different times are the same once the finer one is
+// truncated to the precision of the coarser one
+func sameAtCoarserPrecision(t1 time.Time, t2 time.Time) (time.Duration, bool) {
+	precision := timePrecision(t1)
+	if precision2 := timePrecision(t2); precision2 > precision {
+		precision = precision2
+	}
+	if precision == time.Nanosecond {
+		return precision, false
+	}
+	return precision, t1.Truncate(precision).Equal(t2.Truncate(precision))
+}
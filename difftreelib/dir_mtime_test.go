@@ -0,0 +1,99 @@
+package difftreelib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompareDirMtimes(t *testing.T) {
+	path1, path2, cleanup := makeTrees(t,
+		testTree{"dir/file": "1"},
+		testTree{"dir/file": "1"})
+	defer cleanup()
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name              string
+		mtime2            time.Time
+		options           DifftreeOptions
+		wantDiffer        int
+		wantPrecisionOnly int
+	}{
+		{"not checked", mtime.Add(time.Hour), DifftreeOptions{}, 0, 0},
+		{"same", mtime, DifftreeOptions{CheckDirMtime: true}, 0, 0},
+		{"different", mtime.Add(time.Hour), DifftreeOptions{CheckDirMtime: true}, 1, 0},
+		{"within the tolerance", mtime.Add(time.Second),
+			DifftreeOptions{CheckDirMtime: true, DirMtimeTolerance: time.Minute}, 0, 0},
+		{"beyond the tolerance", mtime.Add(time.Hour),
+			DifftreeOptions{CheckDirMtime: true, DirMtimeTolerance: time.Minute}, 1, 0},
+		{"finer precision", mtime.Add(123456789),
+			DifftreeOptions{CheckDirMtime: true}, 1, 0},
+		{"finer precision only", mtime.Add(123456789),
+			DifftreeOptions{CheckDirMtime: true, MtimePrecisionOnly: true}, 0, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The roots' mtimes are the same; only those of dir differ
+			times := []struct {
+				path  string
+				mtime time.Time
+			}{
+				{path1, mtime},
+				{path2, mtime},
+				{filepath.Join(path1, "dir"), mtime},
+				{filepath.Join(path2, "dir"), test.mtime2},
+			}
+			for _, pathTime := range times {
+				err := os.Chtimes(pathTime.path, pathTime.mtime, pathTime.mtime)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			options := test.options
+			stats := compareQuietly(t, path1, path2, &options)
+			if stats.DirDifferentMtime != test.wantDiffer {
+				t.Errorf("DirDifferentMtime = %d, want %d", stats.DirDifferentMtime,
+					test.wantDiffer)
+			}
+			if stats.DirMtimePrecision != test.wantPrecisionOnly {
+				t.Errorf("DirMtimePrecision = %d, want %d", stats.DirMtimePrecision,
+					test.wantPrecisionOnly)
+			}
+			// The entries are the same, whatever the mtimes
+			if stats.DirDifferent != 0 {
+				t.Errorf("DirDifferent = %d, want 0", stats.DirDifferent)
+			}
+		})
+	}
+}
+
+func TestSameAtCoarserPrecision(t *testing.T) {
+	base := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name          string
+		t1            time.Time
+		t2            time.Time
+		wantPrecision time.Duration
+		wantSame      bool
+	}{
+		{"seconds", base.Add(123456789), base, time.Second, true},
+		{"milliseconds", base.Add(123456789), base.Add(123 * time.Millisecond),
+			time.Millisecond, true},
+		{"microseconds", base.Add(123456789), base.Add(123456 * time.Microsecond),
+			time.Microsecond, true},
+		{"NTFS", base.Add(123456789), base.Add(123456700), 100 * time.Nanosecond, true},
+		{"another second", base.Add(time.Second + 5), base, time.Second, false},
+		{"both nanoseconds", base.Add(123456789), base.Add(123456781),
+			time.Nanosecond, false},
+	}
+	for _, test := range tests {
+		precision, same := sameAtCoarserPrecision(test.t1, test.t2)
+		if precision != test.wantPrecision || same != test.wantSame {
+			t.Errorf("%s: sameAtCoarserPrecision() = %v, %v, want %v, %v", test.name,
+				precision, same, test.wantPrecision, test.wantSame)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package difftreelib
+
+import (
+	"fmt"
+	"sort"
+)
+
+// For ReportDuplicates, the paths of the files in each tree, by the
+// hashes computed while comparing them
+type duplicateFinder struct {
+	tree1 map[string][]string
+	tree2 map[string][]string
+}
+
+func newDuplicateFinder() *duplicateFinder {
+	return &duplicateFinder{
+		tree1: make(map[string][]string),
+		tree2: make(map[string][]string),
+	}
+}
+
+func (self *duplicateFinder) add(entry *treeEntry) {
+	if entry.hash1 != nil {
+		key := string(entry.hash1)
+		self.tree1[key] = append(self.tree1[key], entry.path1)
+	}
+	if entry.hash2 != nil {
+		key := string(entry.hash2)
+		self.tree2[key] = append(self.tree2[key], entry.path2)
+	}
+}
+
+// The groups of paths which share a hash, each sorted, in order of
+// their first paths
+func duplicateGroups(byHash map[string][]string) [][]string {
+	var groups [][]string
+	for _, paths := range byHash {
+		if len(paths) > 1 {
+			sort.Strings(paths)
+			groups = append(groups, paths)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i][0] < groups[j][0]
+	})
+	return groups
+}
+
+// Count the groups of duplicates in each tree, and print them, unless
+// the results aren't printed
+func (s *ComparisonEngine) reportDuplicates(options *DifftreeOptions) {
+	groups1 := duplicateGroups(s.duplicates.tree1)
+	groups2 := duplicateGroups(s.duplicates.tree2)
+	s.stats.DuplicateGroups = len(groups1) + len(groups2)
+
+	if options.Quiet || s.events != nil || s.htmlReport != nil || s.itemize {
+		return
+	}
+	printDuplicateGroups("DUPLICATES IN TREE1", groups1)
+	printDuplicateGroups("DUPLICATES IN TREE2", groups2)
+}
+
+func printDuplicateGroups(title string, groups [][]string) {
+	if len(groups) == 0 {
+		return
+	}
+	fmt.Printf("%s\n========================================\n", title)
+	for i, paths := range groups {
+		fmt.Printf("Group %d:\n", i+1)
+		for _, path := range paths {
+			fmt.Printf("    %s\n", path)
+		}
+		fmt.Print("\n")
+	}
+}
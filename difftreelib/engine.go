@@ -1,14 +1,20 @@
 package difftreelib
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"math/rand"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 /*
@@ -35,48 +41,821 @@ import (
                      +----------+
 */
 
+const (
+	HashSHA1   = "sha1"
+	HashBLAKE3 = "blake3"
+)
+
+const (
+	OutputText    = "text"
+	OutputGitHub  = "github"
+	OutputHTML    = "html"
+	OutputItemize = "itemize"
+)
+
+const (
+	PermFormatSymbolic = "symbolic"
+	PermFormatOctal    = "octal"
+)
+
 type DifftreeOptions struct {
 	CheckHashes bool
 	IgnoreFiles map[string]bool
+
+	// The hash used by CheckHashes; HashSHA1 if empty.
+	// With HashBLAKE3, large files are hashed in parallel segments,
+	// and their hashes, called "segmented BLAKE3", aren't the digests
+	// b3sum prints; see blake3.go. Not with RecordAllHashes, though.
+	HashAlgorithm string
+
+	// Hash regular files even when their sizes differ, instead of
+	// declaring them a mismatch from their sizes alone, for when the
+	// sizes can't be trusted. A mismatch's description then has both
+	// sizes and both hashes, and files whose hashes match are perfect
+	// matches, whatever their sizes. This needs CheckHashes.
+	AlwaysHash bool
+
+	// When regular files' sizes differ, check whether the smaller is
+	// the start of the larger, by hashing that much of each, and if
+	// so, report it as truncated, as from an interrupted copy, instead
+	// of as a mismatch. This needs CheckHashes, and does nothing
+	// without it.
+	DetectTruncated bool
+
+	// Directories to prune from the walk, matched against the path
+	// relative to the tree root. A pattern starting with "./" or "/"
+	// is anchored at the root; any other pattern matches at any depth.
+	// Unlike IgnoreFiles, files with the same name are still compared.
+	ExcludeDirs []string
+
+	// Only compare the layout of the trees: whether each path is in
+	// both, and is the same type of file. The entries of directories
+	// are compared, but no metadata, sizes, contents or symlink
+	// targets are, so only missing, extra, and different types of
+	// paths are reported.
+	StructureOnly bool
+
+	// Normally, the walk descends into every directory in both trees,
+	// even one whose own permissions or other metadata differ, so its
+	// contents are compared; only a directory which is something else
+	// in tree2 isn't descended into. With this, a directory whose
+	// metadata differs isn't either, and is reported on its own.
+	// Either way, unless CombineMetadataDiffs is set, the result of
+	// such a directory is its metadata difference, and its list of
+	// entries isn't compared with tree2's.
+	PruneDifferingDirs bool
+
+	// Files whose contents don't matter, like logs and caches. They
+	// must exist in both trees, as the same type, and their metadata
+	// is compared, but their sizes and contents aren't. A pattern
+	// without a "/" is matched against the file's name, at any depth,
+	// like "*.log"; one with a "/", against its path relative to the
+	// tree root, like "var/cache/*". See filepath.Match for the syntax.
+	MetadataOnlyPatterns []string
+
+	// Before comparing, hash each directory of both trees bottom-up,
+	// by the hashes of its entries, as TreeHash does for a whole
+	// tree. A directory with the same hash in both is reported as
+	// DTSameSubtree, and its contents are neither compared nor
+	// counted. This reads every file in both trees, so it helps most
+	// when they are mostly identical. Ownership, xattrs and times
+	// aren't in the hashes.
+	DirectoryMerkle bool
+
+	// Don't descend into directories of the first tree which are on a
+	// different device from its root, except for the mount points in
+	// IncludeMounts, given relative to the root. Each skipped directory
+	// is reported as ignored. This only applies where files have device
+	// numbers.
+	OneFileSystem bool
+	IncludeMounts []string
+
+	// Compare the creation times of files, where the platform and
+	// filesystem record them: via statx on Linux, and Stat_t on macOS,
+	// FreeBSD and NetBSD. Elsewhere, or if either file has no recorded
+	// creation time, this is a no-op. Times within BirthtimeTolerance
+	// of each other are considered the same.
+	CheckBirthtime     bool
+	BirthtimeTolerance time.Duration
+
+	// Compare the project IDs of files and directories, which assign
+	// them to project quotas. This reads them with the FS_IOC_FSGETXATTR
+	// ioctl, as on XFS and ext4 on Linux. Elsewhere, or on filesystems
+	// without project IDs, this is a no-op.
+	CheckProjectID bool
+
+	// Compare the generation numbers of the inodes of files and
+	// directories, which change when an inode number is reused, for
+	// forensic checks of a tree against a copy on the same filesystem,
+	// such as a snapshot. This reads them with the FS_IOC_GETVERSION
+	// ioctl, as on ext4 and XFS on Linux. Elsewhere, or on filesystems
+	// without generation numbers, this is a no-op.
+	CheckInodeGeneration bool
+
+	// Compare the modification times of directories, which change
+	// when entries are added, removed or renamed. Times within
+	// DirMtimeTolerance of each other are considered the same. This
+	// is reported apart from differences in the entries themselves.
+	CheckDirMtime     bool
+	DirMtimeTolerance time.Duration
+
+	// With CheckDirMtime, report mtimes which are the same once the
+	// finer one is truncated to the precision of the coarser one, as
+	// when one filesystem stores whole seconds, as kDirMtimePrecision,
+	// which is only a note, not a difference. The precision of each
+	// is judged by its trailing zeros.
+	MtimePrecisionOnly bool
+
+	// Only compare these permission bits. If zero, all of them
+	// (0777) are compared. The setuid, setgid, and sticky bits of
+	// directories are always compared. The permissions of symlinks
+	// are never compared.
+	PermMask os.FileMode
+
+	// Changes of permissions which are expected. A path whose
+	// permissions changed as one of these lists, and which otherwise
+	// matches, is reported as an allowed change, which isn't drift.
+	// The whole permissions are matched, regardless of PermMask.
+	AllowedPermChanges []PermTransition
+
+	// How permissions are shown in descriptions: PermFormatSymbolic,
+	// as in "-rwxr-xr-x", which is the default, or PermFormatOctal,
+	// as in "0755".
+	PermFormat string
+
+	// Compare the owning uids and gids, where the platform has them.
+	// A change between two uids which are both in IgnoreUIDs, such
+	// as dynamically allocated ones, isn't reported; likewise for
+	// gids in IgnoreGIDs.
+	CheckOwnership bool
+	IgnoreUIDs     []IDRange
+	IgnoreGIDs     []IDRange
+
+	// Compare the extended attributes, where the platform has them,
+	// other than those named in IgnoreXattrs, such as
+	// "security.selinux", which can differ between hosts.
+	CheckXattrs  bool
+	IgnoreXattrs []string
+
+	// Report all of the metadata differences of a path together, rather
+	// than only the first. If the contents match, the result is
+	// "metadata differs"; otherwise, the metadata differences are added
+	// to the description of the content difference.
+	CombineMetadataDiffs bool
+
+	// When the types or permissions differ, describe both full modes,
+	// as in "drwxr-xr-x" and "Lrwxrwxrwx", in one line, rather than
+	// only the part which differs.
+	ShowFullModeDiff bool
+
+	// Compare the MIME types of regular files, as guessed from their
+	// first 512 bytes by http.DetectContentType. Files whose types
+	// differ, such as a PNG which became a JPEG, are reported as
+	// having different content types, rather than as mismatches.
+	CompareContentType bool
+
+	// Compare symlink targets by where they point relative to their
+	// tree roots, rather than by their raw text, so that "./foo" and
+	// an absolute path to the same place are considered equal.
+	ResolveSymlinkTargets bool
+
+	// When two directories have different numbers of entries, report
+	// that without building and diffing the sets of names. The names
+	// of the differing entries are then not listed. This does nothing
+	// with ExcludeDirs or SeparateDotfileDiffs, which need the names.
+	QuickDirCompare bool
+
+	// When two directories have the same entry names, also compare
+	// the types of the entries, and, with CompareDirEntrySizes, the
+	// sizes of regular files, so that such a change is reported for
+	// the directory, as well as for the entry itself.
+	CompareDirEntryAttrs bool
+	CompareDirEntrySizes bool
+
+	// When a path in both trees is the same file, as when tree2 is a
+	// hard-linked copy of tree1, report a perfect match without
+	// comparing metadata or reading contents. This only applies
+	// where files have device and inode numbers.
+	SkipSameInode bool
+
+	// The number of goroutines comparing paths. If zero, the number of
+	// CPUs is used, or, with AutoTuneWorkers, a number based on whether
+	// the two roots are on different devices. Workers overrides
+	// AutoTuneWorkers.
+	Workers         int
+	AutoTuneWorkers bool
+
+	// Walk each top-level directory of tree1 in its own goroutine,
+	// with its own Workers, as well as the root and the files in it,
+	// so that subtrees on different disks can be read in parallel.
+	// With many more workers, it helps when opening files is slow,
+	// but on a fast local disk it is slower than one walk. The results
+	// are reported together, in no particular order. This is meant for
+	// a few top-level directories, as each has a full set of workers.
+	// With sampling, each walk samples on its own, with a seed derived
+	// from SampleSeed. It can't be used with PathsFrom.
+	PartitionTopLevel bool
+
+	// Stop reading paths to compare after this long. The paths
+	// already read are still compared, and everything found is
+	// reported, but Stats.Incomplete is set. Zero means no limit.
+	Deadline time.Duration
+
+	// Likewise, stop reading paths to compare once this many bytes
+	// of file contents have been read, to hash or otherwise compare
+	// regular files. The comparisons in progress finish, so somewhat
+	// more may be read. Zero means no limit.
+	MaxTotalBytesRead int64
+
+	// Likewise, stop reading paths to compare once a difference is
+	// reported, including an error. Differences in the baseline don't
+	// count.
+	StopOnFirstDiff bool
+
+	// Multiply the number of paths which can be in flight between
+	// the walk, the workers, and the report by this. A higher depth
+	// lets the walk get further ahead of the workers. That doesn't
+	// help when the workers are what's slow, as when opening files
+	// is, nor on a local disk. Zero is the same as one.
+	PipelineDepth int
+
+	// Retry stat'ing and hashing a file up to MaxRetries times when
+	// it fails with a transient error, as can happen on network
+	// filesystems. The wait starts at RetryBackoff and doubles after
+	// each attempt. IsRetryableError decides which errors are
+	// transient; if nil, EINTR and ESTALE are.
+	MaxRetries       int
+	RetryBackoff     time.Duration
+	IsRetryableError func(error) bool
+
+	// When small text files don't match, show both of their contents.
+	// Files that are over TextSizeLimit, or look binary, aren't shown.
+	ShowSmallFileContents bool
+
+	// Compare text files with CRLF line endings converted to LF.
+	// Files that match only after this are reported separately from
+	// perfect matches. Files that are over TextSizeLimit, or look
+	// binary, are compared unchanged.
+	NormalizeLineEndings bool
+
+	// Compare the contents of text files case-insensitively, after
+	// NormalizeLineEndings, if it is set. This is only for the contents;
+	// file names are still compared exactly. Files that match only
+	// after this are reported separately from perfect matches.
+	CaseInsensitiveContent bool
+
+	// Compare text files with the spaces and tabs at the end of each
+	// line removed. Files that match only after this are reported
+	// separately from perfect matches.
+	IgnoreTrailingWhitespace bool
+
+	// Compare text files without a UTF-8 byte order mark at their
+	// start. Files that match only after this are reported separately
+	// from perfect matches.
+	IgnoreBOM bool
+
+	// The largest file, in bytes, that is read into memory for the
+	// text options, from NormalizeLineEndings to IgnoreBOM. If zero,
+	// 4KB is used.
+	TextSizeLimit int64
+
+	// When regular files don't match, add how similar they are to
+	// the description, as the percentage of their content-defined
+	// chunks which they share, to tell a small edit from a rewrite.
+	// This reads both files completely.
+	SimilarityCompare bool
+
+	// Compare the contents of regular files by running this command,
+	// with the two paths appended to its arguments, in place of the
+	// size, hash and text checks. Exit status 0 means they match.
+	// It is split into arguments on whitespace, without a shell.
+	// A command running longer than CompareCommandTimeout is an
+	// error; if that is zero, one minute is used.
+	CompareCommand        string
+	CompareCommandTimeout time.Duration
+
+	// If set, copy both versions of each mismatched file into this
+	// directory, as <relpath>.tree1 and <relpath>.tree2, for review
+	// with other tools. Files over ExportSizeLimit bytes aren't
+	// copied; if it is zero, 10MB is used.
+	ExportDiffsDir  string
+	ExportSizeLimit int64
+
+	// Compare only this fraction, from 0 to 1, of the non-directories,
+	// chosen at random, for an approximate comparison of huge trees.
+	// All directories are still compared. The choice is repeatable
+	// for a given SampleSeed. Zero, like one, compares everything.
+	SampleRate float64
+	SampleSeed int64
+
+	// Without CheckHashes, regular files whose sizes match are assumed
+	// to match. With this, a fraction of them, from 0 to 1, chosen at
+	// random, are hashed to verify that, and the summary counts how
+	// many were verified and how many assumed. The choice is
+	// repeatable for a given HashSampleSeed. With CheckHashes, all of
+	// them are hashed, so this has no effect.
+	HashSampleRate float64
+	HashSampleSeed int64
+
+	// Stop printing results after this many, although they are still
+	// counted in the summary. Zero means no limit.
+	MaxReport int
+
+	// Compare symlinks by the contents of the regular files which
+	// they finally resolve to, following chains of symlinks, as well
+	// as by their targets. Symlinks whose targets differ, but which
+	// resolve to the same contents, are reported separately, as are
+	// those with the same targets, but different contents. Symlinks
+	// which don't resolve to regular files are compared as usual.
+	CompareResolvedContent bool
+
+	// Check each pair of matching symlinks together with what they
+	// point to: following any chain of symlinks, both must end inside
+	// their trees, at regular files with the same contents, or at
+	// directories. Otherwise, including when a target is missing or a
+	// loop, the pair is reported as kDifferentResolvedContent.
+	VerifySymlinkTargets bool
+
+	// Report symlinks which are broken in both trees, whose targets
+	// don't exist, as ignored, without comparing their targets.
+	IgnoreBrokenSymlinks bool
+
+	// Warn about symlinks whose targets are outside of their tree.
+	FlagEscapingSymlinks bool
+
+	// Warn about paths in either tree with any of these permission
+	// bits, such as 0002 for world-writable ones, instead of
+	// comparing them. Symlinks are not checked.
+	FlagPermissions os.FileMode
+
+	// While comparing, write the counts of the main results so far
+	// to CountsOutput every CountsInterval, to watch long runs. If
+	// CountsOutput is nil, os.Stderr is used.
+	CountsInterval time.Duration
+	CountsOutput   io.Writer
+
+	// Write a profile of the time spent comparing the paths in each
+	// directory to Profile, in the folded-stack format read by
+	// flamegraph tools. See profile.go.
+	Profile io.Writer
+
+	// Record how long each stage of the comparison takes, for
+	// SummarizeTiming.
+	Timing bool
+
+	// Don't print the results. They are still counted.
+	Quiet bool
+
+	// Instead of printing each difference as it is found, print them
+	// all at the end as an indented tree, with each path's result
+	// tag, but not its description. Directories are shown only if
+	// they lead to differences. MaxReport doesn't apply.
+	TreeView bool
+
+	// How each difference is printed; OutputText if empty. With
+	// OutputGitHub, each is a GitHub Actions workflow command, so
+	// that it is shown as an annotation on the file. See github.go.
+	// With OutputHTML, a standalone page with the counts and a table
+	// of the differences is printed at the end instead; see html.go.
+	// With OutputItemize, each is a line like rsync's
+	// --itemize-changes, and nothing else is printed; see itemize.go.
+	OutputFormat string
+
+	// Names are compared as raw bytes, whether or not they are valid
+	// UTF-8. Escape the paths and descriptions which aren't valid
+	// UTF-8 when printing them as text or HTML, as escapeInvalidUTF8
+	// describes, instead of writing their bytes as they are. The
+	// Events are always escaped.
+	EscapeInvalidNames bool
+
+	// For debugging, write a line to DumpOrder for each path as its
+	// result arrives, with the order in which it was walked, or
+	// listed in PathsFrom, and the path, separated by a tab. The
+	// results arrive out of walk order, as the workers finish them.
+	// With PartitionTopLevel, each partition is numbered from zero.
+	DumpOrder io.Writer
+
+	// If set, write a JSON object to Events for each result as it is
+	// produced, with periodic progress objects, and a final summary.
+	// See events.go for the format.
+	Events io.Writer
+
+	// Render each result's text report, and its event, in the
+	// comparison workers, instead of in the one goroutine which
+	// handles the results in turn, so that it only writes them, in
+	// the same order as it would have. This helps when most paths
+	// are results to write. The HTML page is rendered at the end, as
+	// usual.
+	PrerenderOutput bool
+
+	// For an audit trail, hash every regular file in tree1, and those
+	// in tree2 at the same paths, even when the comparison didn't need
+	// to, and write a line for each to HashRecord; see hash_record.go
+	// for the format. Files only in tree2 aren't hashed. This needs
+	// CheckHashes, whose HashAlgorithm is used; large files aren't
+	// hashed in segments, so that the hashes can be checked by b3sum
+	// or VerifyManifest.
+	RecordAllHashes bool
+	HashRecord      io.Writer
+
+	// After the results, list the groups of files with the same
+	// contents within each tree, by the hashes computed to compare
+	// them, and count them in Stats.DuplicateGroups. This needs
+	// CheckHashes. Only files which were hashed are included: not
+	// those whose sizes differed from their counterparts, or which are
+	// only in one tree, unless with RecordAllHashes, nor empty files.
+	ReportDuplicates bool
+
+	// Besides comparing the trees path by path, hash every regular
+	// file in both, and after the results, list the contents which are
+	// only in one tree, wherever they are in either, as for a
+	// content-addressed store. Stats.ContentOnlyInTree1 and
+	// ContentOnlyInTree2 count them. This needs CheckHashes, whose
+	// HashAlgorithm is used.
+	ContentIndexMode bool
+
+	// The tags of results, like "DTDiffDirMtime", which are reported
+	// and counted in Stats as usual, but left out of ExitSeverity, so
+	// that they can be shown without failing a job which checks it.
+	IgnoreForExit []string
+
+	// If set, insert a row for each result into the "results" table
+	// of ResultsDB, creating it if needed, for querying with SQL.
+	// See results_db.go for the columns.
+	ResultsDB *sql.DB
+
+	// Compare files of the same size block by block, reading all of
+	// their contents, and list the offsets of the blocks which differ.
+	// This is done instead of CheckHashes. If BlockSize is zero,
+	// 1MB blocks are used.
+	BlockCompare bool
+	BlockSize    int64
+
+	// Compare the contents of regular files, by their hashes, with
+	// the bytes in these ranges left out, as for headers with
+	// timestamps at fixed offsets. This takes the place of
+	// CheckHashes and BlockCompare. Files of different sizes are
+	// still mismatches, so the ranges only make sense for formats
+	// where both files have the same length.
+	IgnoreByteRanges []ByteRange
+
+	// Only compare the regular files which are executable by anyone
+	// in tree1. Others are skipped, and not counted. Directories are
+	// still descended into.
+	ExecutablesOnly bool
+
+	// Don't compare regular files if either of them is zero-length;
+	// they are reported as ignored.
+	IgnoreEmptyFiles bool
+
+	// If set, don't walk tree1. Instead, read the paths to compare,
+	// relative to the roots, from PathsFrom, one per line. Listed
+	// directories are compared, but not descended into.
+	PathsFrom io.Reader
+
+	// Differences listed in Baseline are expected, so they are
+	// counted as baselined, and not reported. After the comparison,
+	// the differences found are written to WriteBaseline, sorted by
+	// path, so it can be the Baseline of a later comparison, or be
+	// compared with another run's by CompareReports. See baseline.go
+	// for the format.
+	Baseline      io.Reader
+	WriteBaseline io.Writer
+
+	// For incremental comparisons: after the comparison, the regular
+	// files which matched are written to WriteMatched, with their
+	// sizes and mtimes, sorted by path. A later comparison given them
+	// as SkipMatched, from ReadMatchedFiles, takes each file whose size
+	// and mtimes in both trees are unchanged as still matching,
+	// without reading it. Its metadata is still compared. See
+	// matched.go for the format. WriteMatched needs CheckHashes, so
+	// that only files whose contents were compared are written.
+	WriteMatched io.Writer
+	SkipMatched  MatchedFiles
+
+	// If set, the relative path of each path in tree1 is passed
+	// through PathMap to find the path to compare it with in tree2,
+	// for trees with systematic differences in layout. See
+	// PrefixPathMap. The entries of directories are still compared
+	// by name, so the directories where the layouts differ are
+	// reported as having different entries.
+	PathMap func(relPath string) string
+
+	// When two directories' entries differ only in dotfiles, that
+	// is, names starting with ".", report them as such, rather than
+	// as having different entries, to tell hidden-file noise apart.
+	SeparateDotfileDiffs bool
+
+	// Skip dotfiles, and don't descend into dot-directories, without
+	// reporting them, and leave them out of directory comparisons.
+	IgnoreDotfiles bool
+
+	// Compare returns an error if path1 and path2 are the same
+	// directory, as everything would trivially match, unless this
+	// is set.
+	AllowSameRoot bool
+
+	// Don't compare the entries of directories, only those of files.
+	// Directories are still descended into, and their types and
+	// permissions are still compared.
+	SkipDirectoryComparison bool
+
+	// Change tree2 to match tree1: with ApplyMissing, copy paths
+	// missing from tree2, which are directories, regular files or
+	// symlinks, and with ApplyPerms, copy the permissions of paths
+	// whose permissions differ. Nothing is deleted. Each fix is
+	// reported after the result. With ApplyDryRun, the fixes are
+	// only reported, not made.
+	ApplyMissing bool
+	ApplyPerms   bool
+	ApplyDryRun  bool
+
+	// After the walk, match the files missing from tree2 with files
+	// that are only in tree2, by their content hashes, and report the
+	// matches as moved rather than missing. The files only in tree2
+	// are found from the directories whose entries differ.
+	DetectRenames bool
+
+	// Like DetectRenames, but only match files with the same content
+	// in the same directory, whose names differ only in case, as when
+	// a tree was copied through a case-insensitive filesystem. These
+	// are reported as renamed in case. With both options, these
+	// matches are made first.
+	DetectCaseRenames bool
+
+	// After the walk, report the entries at the top level of tree2
+	// that are not in tree1. This does not look for extras deeper
+	// in tree2.
+	CheckRootExtras bool
+
+	// Instead of describing all of the entries which differ between
+	// two directories in the directory's result, report each entry
+	// only in tree2 as its own kExtra result, after the directory.
+	// Entries only in tree1 are already reported as kMissing. This
+	// includes the top level, as with CheckRootExtras.
+	ExpandDirDiffs bool
+
+	// Print the differences in the structure of directories, in their
+	// entries, dotfiles, mtimes or entry attributes, together after
+	// all of the other results, in order of their paths, instead of
+	// as they are found.
+	GroupDirDiffs bool
+}
+
+func (self *DifftreeOptions) sampling() bool {
+	return self.SampleRate > 0 && self.SampleRate < 1
+}
+
+func (self *DifftreeOptions) hashSampling() bool {
+	return self.HashSampleRate > 0 && !self.CheckHashes
+}
+
+func (self *DifftreeOptions) pipelineDepth() int {
+	if self.PipelineDepth == 0 {
+		return 1
+	}
+	return self.PipelineDepth
+}
+
+func (self *DifftreeOptions) permMask() os.FileMode {
+	if self.PermMask == 0 {
+		return os.ModePerm
+	}
+	return self.PermMask
+}
+
+func (self *DifftreeOptions) hashName() string {
+	switch self.HashAlgorithm {
+	case HashBLAKE3:
+		return "BLAKE3"
+	default:
+		return "SHA1"
+	}
+}
+
+// The name of the hash getFileHash makes of a file of this size
+func (self *DifftreeOptions) fileHashName(size int64) string {
+	if self.HashAlgorithm == HashBLAKE3 && !self.RecordAllHashes &&
+		size >= parallelHashThreshold {
+		return "segmented BLAKE3"
+	}
+	return self.hashName()
+}
+
+// Whether OneFileSystem allows crossing into this mount point
+func (self *DifftreeOptions) includesMount(relPath string) bool {
+	for _, mount := range self.IncludeMounts {
+		mount = filepath.FromSlash(mount)
+		mount = strings.TrimPrefix(mount, "."+string(filepath.Separator))
+		mount = strings.TrimPrefix(mount, string(filepath.Separator))
+		if filepath.Clean(mount) == relPath {
+			return true
+		}
+	}
+	return false
 }
 
+func (self *DifftreeOptions) metadataOnly(relPath string) bool {
+	for _, pattern := range self.MetadataOnlyPatterns {
+		name := filepath.ToSlash(relPath)
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(name)
+		}
+		// The patterns were checked by Compare
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *DifftreeOptions) excludesDir(relPath string) bool {
+	for _, pattern := range self.ExcludeDirs {
+		pattern = filepath.FromSlash(pattern)
+		anchored := false
+		if strings.HasPrefix(pattern, "."+string(filepath.Separator)) {
+			pattern = pattern[2:]
+			anchored = true
+		} else if strings.HasPrefix(pattern, string(filepath.Separator)) {
+			pattern = pattern[1:]
+			anchored = true
+		}
+		pattern = filepath.Clean(pattern)
+
+		if relPath == pattern {
+			return true
+		}
+		if !anchored && strings.HasSuffix(relPath, string(filepath.Separator)+pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compare the trees at path1 and path2. The engine is reset first,
+// so the counts in its summary are for this comparison only.
 func (s *ComparisonEngine) Compare(path1 string, path2 string, options *DifftreeOptions) error {
+	s.Reset()
+	// A Stop is for this comparison only
+	defer atomic.StoreInt32(&s.stopRequested, 0)
+
+	switch options.HashAlgorithm {
+	case "", HashSHA1, HashBLAKE3:
+	default:
+		return fmt.Errorf("Unknown hash algorithm %q", options.HashAlgorithm)
+	}
+	switch options.OutputFormat {
+	case "", OutputText, OutputGitHub, OutputHTML, OutputItemize:
+	default:
+		return fmt.Errorf("Unknown output format %q", options.OutputFormat)
+	}
+	if options.OutputFormat == OutputHTML && options.TreeView {
+		return errors.New("TreeView cannot be used with OutputHTML")
+	}
+	if options.OutputFormat == OutputItemize && options.TreeView {
+		return errors.New("TreeView cannot be used with OutputItemize")
+	}
+	if options.RecordAllHashes && (!options.CheckHashes || options.HashRecord == nil) {
+		return errors.New("RecordAllHashes needs CheckHashes and HashRecord")
+	}
+	if options.AlwaysHash && !options.CheckHashes {
+		return errors.New("AlwaysHash needs CheckHashes")
+	}
+	if options.ContentIndexMode && !options.CheckHashes {
+		return errors.New("ContentIndexMode needs CheckHashes")
+	}
+	if options.WriteMatched != nil && !options.CheckHashes {
+		return errors.New("WriteMatched needs CheckHashes")
+	}
+	if err := options.checkCompareCommand(); err != nil {
+		return err
+	}
+	switch options.PermFormat {
+	case "", PermFormatSymbolic, PermFormatOctal:
+	default:
+		return fmt.Errorf("Unknown permission format %q", options.PermFormat)
+	}
+
+	// Keep the results so far, even if the comparison fails
+	defer s.closeResultsDB()
+	if err := s.startReport(options); err != nil {
+		return err
+	}
+	if s.htmlReport != nil {
+		s.htmlReport.title = fmt.Sprintf("%s vs %s", path1, path2)
+	}
+
+	for _, pattern := range options.MetadataOnlyPatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Bad metadata-only pattern %q: %v", pattern, err)
+		}
+	}
+
+	if options.PipelineDepth < 0 {
+		return fmt.Errorf("PipelineDepth %d is negative", options.PipelineDepth)
+	}
+
+	if options.PartitionTopLevel && options.PathsFrom != nil {
+		return errors.New("PartitionTopLevel cannot be used with PathsFrom")
+	}
+
+	if options.SampleRate < 0 || options.SampleRate > 1 {
+		return fmt.Errorf("SampleRate %v is not between 0 and 1", options.SampleRate)
+	}
+	if options.sampling() {
+		s.stats.SampleRate = options.SampleRate
+	}
+	if options.HashSampleRate < 0 || options.HashSampleRate > 1 {
+		return fmt.Errorf("HashSampleRate %v is not between 0 and 1", options.HashSampleRate)
+	}
+	if options.hashSampling() {
+		s.stats.HashSampleRate = options.HashSampleRate
+	}
+	s.skipDirectoryComparison = options.SkipDirectoryComparison
+	if options.Deadline > 0 {
+		s.deadline = time.Now().Add(options.Deadline)
+	}
+	if options.MaxTotalBytesRead > 0 {
+		s.readBudget = &readBudget{limit: options.MaxTotalBytesRead}
+	}
+	if options.Profile != nil {
+		s.profile = make(pathProfile)
+	}
+	if options.ReportDuplicates {
+		if !options.CheckHashes {
+			return errors.New("ReportDuplicates needs CheckHashes")
+		}
+		s.duplicates = newDuplicateFinder()
+	}
 
 	// No trailing slashes, etc.
 	path1 = path.Clean(path1)
 	path2 = path.Clean(path2)
 
-	numWorkers := runtime.NumCPU()
+	if !options.AllowSameRoot && sameRoot(path1, path2) {
+		return fmt.Errorf("%s and %s are the same directory", path1, path2)
+	}
 
-	// numWorkers + 1 for ReadTreeEntries + 1 for Report
-	numTreeEntries := numWorkers + 2
-	blankEntryChan := make(chan *treeEntry, numTreeEntries)
-	filledEntryChan := make(chan *treeEntry, numWorkers)
-
-	// The length of path1, plus 1 for an additional path separator
-	s.path1RootLen = len(path1) + 1
-	// Account for any path separators at the end
-	for i := len(path1) - 1; i >= 0; i-- {
-		if path1[1] == filepath.Separator {
-			s.path1RootLen--
-		} else {
-			break
+	if options.DirectoryMerkle {
+		s.startDirHashes(path1, path2, options)
+	}
+	if options.ContentIndexMode {
+		if err := s.startContentIndexes(path1, path2, options); err != nil {
+			return err
 		}
 	}
 
+	// With PartitionTopLevel, each top-level directory has its own
+	// reader and workers, besides those of the root
+	var partitions []string
+	numReaders := 1
+	if options.PartitionTopLevel {
+		partitions = topLevelDirs(path1)
+		numReaders += len(partitions)
+		logInfo("Using %d partitions", numReaders)
+	}
+
+	numWorkers := chooseNumWorkers(path1, path2, options)
+	logInfo("Using %d workers", numWorkers*numReaders)
+	if options.Timing {
+		s.timing.workers = numWorkers * numReaders
+		defer recordDuration(&s.timing.total, time.Now())
+	}
+
+	// numWorkers + 1 for ReadTreeEntries, for each reader, + 1 for
+	// Report, times the PipelineDepth
+	depth := options.pipelineDepth()
+	numTreeEntries := (numReaders*(numWorkers+1) + 1) * depth
+	blankEntryChan := make(chan *treeEntry, numTreeEntries)
+	filledEntryChans := make([]chan *treeEntry, numReaders)
+	for i := range filledEntryChans {
+		filledEntryChans[i] = make(chan *treeEntry, numWorkers*depth)
+	}
+
+	s.path1RootLen = rootPrefixLen(path1)
+
 	// Create the comparison workers
-	responseChans := make([]chan *treeEntry, numWorkers)
-	for i := 0; i < numWorkers; i++ {
-		responseChan := make(chan *treeEntry)
-		responseChans[i] = responseChan
-		go s.compareEntries(path2, filledEntryChan, responseChan, options)
+	responseChans := make([]chan *treeEntry, 0, numReaders*numWorkers)
+	for _, filledEntryChan := range filledEntryChans {
+		for i := 0; i < numWorkers; i++ {
+			responseChan := make(chan *treeEntry)
+			responseChans = append(responseChans, responseChan)
+			go s.compareEntries(path2, filledEntryChan, responseChan, options)
+		}
 	}
 
 	// Create the go routine that merges the responsee
 	singleResponseChan := s.mergeResponseChans(responseChans)
 
 	// Create the go routine that reads the tree entries
-	go s.readTreeEntries(path1, path2, blankEntryChan, filledEntryChan, options)
+	switch {
+	case options.PathsFrom != nil:
+		go s.readListedEntries(path1, path2, blankEntryChan, filledEntryChans[0], options)
+	case options.PartitionTopLevel:
+		go s.readPartitions(path1, path2, partitions, blankEntryChan, filledEntryChans, options)
+	default:
+		go s.readTreeEntries(path1, path2, blankEntryChan, filledEntryChans[0], options)
+	}
 
 	// Queue the blank tree entries
 	// There is a fixed number of treeEntries
@@ -89,40 +868,346 @@ func (s *ComparisonEngine) Compare(path1 string, path2 string, options *Difftree
 	}
 
 	// Run the report function
-	return s.reportResults(singleResponseChan, blankEntryChan)
+	err := s.reportResults(singleResponseChan, blankEntryChan, options)
+	if err != nil {
+		return err
+	}
+	// The reader has finished, so these can be read safely
+	switch {
+	case atomic.LoadInt32(&s.deadlinePassed) != 0:
+		s.stats.Incomplete = true
+		s.stats.IncompleteReason = "the deadline passed"
+	case atomic.LoadInt32(&s.readBudgetExhausted) != 0:
+		s.stats.Incomplete = true
+		s.stats.IncompleteReason = fmt.Sprintf("the read budget of %d bytes was exhausted",
+			options.MaxTotalBytesRead)
+	case atomic.LoadInt32(&s.stoppedAtDiff) != 0:
+		s.stats.Incomplete = true
+		s.stats.IncompleteReason = "a difference was found"
+	case atomic.LoadInt32(&s.interrupted) != 0:
+		s.stats.Incomplete = true
+		s.stats.IncompleteReason = "the comparison was interrupted"
+	}
+
+	if options.DetectRenames || options.DetectCaseRenames {
+		s.reportRenames(options)
+	}
+
+	// With ExpandDirDiffs, they were already reported
+	if options.CheckRootExtras && !options.ExpandDirDiffs {
+		err = s.reportRootExtras(path1, path2, options)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.profile != nil {
+		err = s.profile.write(options.Profile)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.finishReport(options)
+}
+
+// Set up the outputs of the results, before the first one
+func (s *ComparisonEngine) startReport(options *DifftreeOptions) error {
+	if len(options.IgnoreForExit) > 0 {
+		var err error
+		s.exitIgnores, err = parseResultTags(options.IgnoreForExit)
+		if err != nil {
+			return fmt.Errorf("IgnoreForExit: %w", err)
+		}
+	}
+	if options.Baseline != nil {
+		var err error
+		s.baseline, err = readBaseline(options.Baseline)
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.Events != nil {
+		s.events = newEventWriter(options.Events)
+	}
+
+	if options.ResultsDB != nil {
+		var err error
+		s.resultsDB, err = newResultsDBWriter(options.ResultsDB)
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.TreeView {
+		s.treeView = newTreeViewNode()
+	}
+
+	if options.OutputFormat == OutputHTML {
+		s.htmlReport = &htmlReport{}
+	}
+	s.itemize = options.OutputFormat == OutputItemize
+	return nil
+}
+
+// Write the outputs which are only complete after the last result
+func (s *ComparisonEngine) finishReport(options *DifftreeOptions) error {
+	if s.treeView != nil {
+		s.treeView.print()
+	}
+
+	if options.GroupDirDiffs {
+		s.printDirDiffs(options)
+	}
+
+	if s.duplicates != nil {
+		s.reportDuplicates(options)
+	}
+
+	if s.contentIndex1 != nil {
+		s.reportContentIndex(options)
+	}
+
+	if s.numUnreported > 0 && s.htmlReport == nil && !s.itemize {
+		fmt.Printf("... and %d more differences (use -all to see)\n\n",
+			s.numUnreported)
+	}
+
+	if options.WriteMatched != nil {
+		err := s.writeMatched(options.WriteMatched)
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.WriteBaseline != nil {
+		err := s.writeBaseline(options.WriteBaseline)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.events != nil {
+		s.events.writeSummary(s.stats)
+	}
+
+	if s.htmlReport != nil {
+		return s.writeHTMLReport(os.Stdout)
+	}
+	return nil
+}
+
+func (s *ComparisonEngine) closeResultsDB() {
+	if s.resultsDB == nil {
+		return
+	}
+	if err := s.resultsDB.close(); err != nil {
+		logError("Cannot write to the results database: %v", err)
+	}
+}
+
+var errDeadlinePassed = errors.New("The deadline passed")
+
+var errReadBudgetExhausted = errors.New("The read budget was exhausted")
+
+var errInterrupted = errors.New("The comparison was interrupted")
+
+var errDiffFound = errors.New("A difference was found")
+
+// Stop the comparison in progress, as for an interrupt: no more paths
+// are read, the paths already read are compared, and everything found
+// is reported, with Stats.Incomplete set. This may be called from any
+// goroutine. A Stop before Compare starts stops that comparison as soon
+// as it does, so a caller stopping on an interrupt can start handling
+// interrupts first.
+func (s *ComparisonEngine) Stop() {
+	atomic.StoreInt32(&s.stopRequested, 1)
+}
+
+// Called by the readers of tree1, before each path. Returns why they
+// should stop reading, if they should.
+func (s *ComparisonEngine) stopReading() error {
+	if s.pastDeadline() {
+		return errDeadlinePassed
+	}
+	if s.pastReadBudget() {
+		return errReadBudgetExhausted
+	}
+	if atomic.LoadInt32(&s.stopRequested) != 0 {
+		atomic.StoreInt32(&s.interrupted, 1)
+		return errInterrupted
+	}
+	if atomic.LoadInt32(&s.stopForDiff) != 0 {
+		atomic.StoreInt32(&s.stoppedAtDiff, 1)
+		return errDiffFound
+	}
+	return nil
+}
+
+// For MaxTotalBytesRead. It is allocated on its own, so that bytesRead
+// is 64-bit aligned for atomic access.
+type readBudget struct {
+	// Added to by all of the workers
+	bytesRead int64
+	limit     int64
+}
+
+// Called by the readers of tree1, which record that they stopped
+func (s *ComparisonEngine) pastReadBudget() bool {
+	if s.readBudget == nil || atomic.LoadInt64(&s.readBudget.bytesRead) < s.readBudget.limit {
+		return false
+	}
+	atomic.StoreInt32(&s.readBudgetExhausted, 1)
+	return true
+}
+
+// Called by the readers of tree1, which record that they stopped
+func (s *ComparisonEngine) pastDeadline() bool {
+	if s.deadline.IsZero() || time.Now().Before(s.deadline) {
+		return false
+	}
+	atomic.StoreInt32(&s.deadlinePassed, 1)
+	return true
+}
+
+// The length of the root as the prefix of the paths under it: the
+// length of the root, plus 1 for an additional path separator, less
+// any which the root ends in, as "/" does
+func rootPrefixLen(root string) int {
+	length := len(root) + 1
+	for i := len(root) - 1; i >= 0; i-- {
+		if root[i] == filepath.Separator {
+			length--
+		} else {
+			break
+		}
+	}
+	return length
+}
+
+// Whether the two roots are the same file, even if by way of symlinks.
+// If either can't be stat'ed, that is left for the comparison to report.
+func sameRoot(path1 string, path2 string) bool {
+	info1, err := os.Stat(path1)
+	if err != nil {
+		return false
+	}
+	info2, err := os.Stat(path2)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(info1, info2)
+}
+
+// With AutoTuneWorkers, the heuristic is: if the two roots are on
+// different devices, each can be kept busy independently, so use twice
+// as many workers as CPUs. Otherwise, or if the devices can't be
+// determined, use as many workers as CPUs.
+func chooseNumWorkers(path1 string, path2 string, options *DifftreeOptions) int {
+	if options.Workers > 0 {
+		return options.Workers
+	}
+
+	numWorkers := runtime.NumCPU()
+	if !options.AutoTuneWorkers {
+		return numWorkers
+	}
+
+	info1, err1 := os.Stat(path1)
+	info2, err2 := os.Stat(path2)
+	if err1 != nil || err2 != nil {
+		return numWorkers
+	}
+	dev1, _, ok1 := deviceAndInode(info1)
+	dev2, _, ok2 := deviceAndInode(info2)
+	if ok1 && ok2 && dev1 != dev2 {
+		return 2 * numWorkers
+	}
+	return numWorkers
 }
 
 func (s *ComparisonEngine) readTreeEntries(path1 string, path2 string,
 	blankEntryChan chan *treeEntry, filledEntryChan chan *treeEntry, options *DifftreeOptions) {
 
 	defer close(filledEntryChan)
+	if options.Timing {
+		defer recordDuration(&s.timing.walk, time.Now())
+	}
+	s.walkTree(path1, path1, path2, false, 0, blankEntryChan, filledEntryChan, options)
+}
+
+// Walk walkRoot, which is path1 or, for PartitionTopLevel, a directory
+// in it, and pass on an entry for each path. With skipTopDirs, the
+// top-level directories are left to their own walks. Each walk samples
+// with its own generators, seeded with SampleSeed and HashSampleSeed
+// plus seedOffset.
+func (s *ComparisonEngine) walkTree(walkRoot string, path1 string, path2 string,
+	skipTopDirs bool, seedOffset int64, blankEntryChan chan *treeEntry,
+	filledEntryChan chan *treeEntry, options *DifftreeOptions) {
 
 	var order int
 
+	var sampler *rand.Rand
+	if options.sampling() {
+		sampler = rand.New(rand.NewSource(options.SampleSeed + seedOffset))
+	}
+	var hashSampler *rand.Rand
+	if options.hashSampling() {
+		hashSampler = rand.New(rand.NewSource(options.HashSampleSeed + seedOffset))
+	}
+
+	// For OneFileSystem
+	var rootDevice uint64
+	if options.OneFileSystem {
+		if info, err := os.Lstat(path1); err == nil {
+			rootDevice, _, _ = deviceAndInode(info)
+		}
+	}
+
 	/* (void) */
-	filepath.Walk(path1, func(path string, info os.FileInfo, err error) error {
-		// Get a blank treeEntry
+	filepath.Walk(walkRoot, func(path string, info os.FileInfo, err error) error {
+		if err := s.stopReading(); err != nil {
+			return err
+		}
+
+		if skipTopDirs && path != path1 && info != nil && info.IsDir() {
+			return filepath.SkipDir
+		}
+
+		// Skip dotfiles before taking an entry, so there's no
+		// result for them at all
+		if options.IgnoreDotfiles && path != path1 && isDotfile(filepath.Base(path)) {
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Get a blank treeEntry. blankEntryChan is only closed by
+		// reportResults after this walk ends, but if it is closed,
+		// there's no entry to fill in and pass on, so just stop.
 		entry, ok := <-blankEntryChan
+		if !ok {
+			return errors.New("Couldn't read blankEntryChan")
+		}
 		defer func() {
-			log.Printf("Walked onto %s", entry.path1)
+			logDebug("Walked onto %s", entry.path1)
 			filledEntryChan <- entry
 		}()
 
-		if !ok {
-			entry.err = errors.New("Couldn't read blankEntryChan")
-			// Stop processing.
-			return entry.err
-		}
-
 		entry.path1 = path
 		entry.order = order
 		entry.info1 = info
+		if len(path) > s.path1RootLen {
+			entry.relPath = path[s.path1RootLen:]
+		}
 		order++
 
 		// Was there an error while walking?
 		if err != nil {
 			entry.result = kError
-			entry.description = fmt.Sprintf("While walking onto %s: %v", path, err)
+			entry.err = fmt.Errorf("While walking onto %s: %w", path, err)
 			// Keep going
 			return nil
 		}
@@ -140,10 +1225,41 @@ func (s *ComparisonEngine) readTreeEntries(path1 string, path2 string,
 			}
 		}
 
+		if info.IsDir() && options.excludesDir(entry.relPath) {
+			entry.result = kIgnored
+			// Don't descend into "path" (a directory)
+			return filepath.SkipDir
+		}
+
+		if options.OneFileSystem && info.IsDir() {
+			device, _, ok := deviceAndInode(info)
+			if ok && device != rootDevice && !options.includesMount(entry.relPath) {
+				logDebug("Not crossing into the mount at %s", path)
+				entry.result = kIgnored
+				// Don't descend into "path" (a directory)
+				return filepath.SkipDir
+			}
+		}
+
+		if sampler != nil && !info.IsDir() {
+			if sampler.Float64() >= options.SampleRate {
+				entry.result = kNotSampled
+				return nil
+			}
+			entry.sampled = true
+		}
+		if hashSampler != nil && !info.IsDir() {
+			entry.hashSampled = hashSampler.Float64() < options.HashSampleRate
+		}
+
 		// If path is a dir, does path2's path exist? If not, skip.
+		// A directory is descended into whatever its own result is,
+		// as that is decided later, by a worker; only a directory
+		// whose type changed isn't, as nothing in it has a
+		// counterpart. It is reported as kDifferentTypes.
 		if info.IsDir() {
 			var statErr error
-			entry.computePath2(s.path1RootLen, path2)
+			entry.computePath2(s.path1RootLen, path2, options.PathMap)
 			entry.info2, statErr = os.Lstat(entry.path2)
 			if statErr == nil {
 				entry.hasInfo2 = true
@@ -151,6 +1267,17 @@ func (s *ComparisonEngine) readTreeEntries(path1 string, path2 string,
 					// Don't descend into "path" (a directory)
 					return filepath.SkipDir
 				}
+				if s.dirHashes1 != nil && s.sameSubtree(entry, options) {
+					entry.result = kDirSameSubtree
+					return filepath.SkipDir
+				}
+				if options.PruneDifferingDirs && path != path1 &&
+					len(entry.compareMetadata(options, false)) > 0 {
+					// It is still compared, by a worker, but
+					// its contents aren't
+					logDebug("Not descending into %s, whose metadata differs", path)
+					return filepath.SkipDir
+				}
 			}
 		}
 		// nil == keep going
@@ -163,16 +1290,36 @@ func (s *ComparisonEngine) compareEntries(path2 string, entryChan chan *treeEntr
 	defer close(responseChan)
 
 	for entry := range entryChan {
-		if entry.result == kIgnored {
+		// Already decided by readTreeEntries
+		if entry.result != kNil {
+			if options.PrerenderOutput {
+				s.prerender(entry, options)
+			}
 			responseChan <- entry
 			continue
 		}
 
 		if !entry.hasInfo2 {
-			entry.computePath2(s.path1RootLen, path2)
+			entry.computePath2(s.path1RootLen, path2, options.PathMap)
 		}
 
-		entry.comparePaths(options)
+		if options.Timing || options.Profile != nil {
+			start := time.Now()
+			entry.comparePaths(options)
+			entry.compareTime = time.Since(start)
+			s.timing.addCompare(start)
+		} else {
+			entry.comparePaths(options)
+		}
+		if options.ExportDiffsDir != "" {
+			entry.exportDiff(options)
+		}
+		if s.readBudget != nil {
+			atomic.AddInt64(&s.readBudget.bytesRead, entry.bytesRead)
+		}
+		if options.PrerenderOutput {
+			s.prerender(entry, options)
+		}
 		responseChan <- entry
 	}
 }
@@ -206,68 +1353,430 @@ func (s *ComparisonEngine) mergeResponseChans(
 }
 
 func (s *ComparisonEngine) reportResults(responseChan chan *treeEntry,
-	blankEntryChan chan *treeEntry) error {
+	blankEntryChan chan *treeEntry, options *DifftreeOptions) error {
 	defer close(blankEntryChan)
 
-	for entry := range responseChan {
+	// The counts are written by this goroutine, so they can't change
+	// while they are being written
+	var countsTicks <-chan time.Time
+	if options.CountsInterval > 0 {
+		ticker := time.NewTicker(options.CountsInterval)
+		defer ticker.Stop()
+		countsTicks = ticker.C
+	}
+	start := time.Now()
+
+	for {
+		var entry *treeEntry
+		select {
+		case <-countsTicks:
+			s.writeCounts(options, time.Since(start))
+			continue
+		case next, ok := <-responseChan:
+			if !ok {
+				return nil
+			}
+			entry = next
+		}
+
 		// TODO(gramirez) - if the order isn't the next sequentially,
 		// before the entry and wait for the correct entry
 
-		// Nothing to see here
-		if entry.result == kPerfectMatch {
-			log.Printf("PerfectMatch: %s", entry.path1)
-			s.countPerfectMatch++
+		if options.DumpOrder != nil {
+			fmt.Fprintf(options.DumpOrder, "%d\t%s\n", entry.order, s.relativePath(entry))
+		}
+
+		if options.Timing {
+			start := time.Now()
+			s.handleResult(entry, options)
+			recordDuration(&s.timing.report, start)
 		} else {
-			var relativePath string
-			if len(entry.path1) > s.path1RootLen {
-				relativePath = entry.path1[s.path1RootLen:]
-			} else {
-				relativePath = entry.path1
-			}
-			switch entry.result {
-			case kError:
-				fmt.Printf("%s: DTError %v\n\n", relativePath, entry.err)
-				s.countError++
-
-			case kMissing:
-				fmt.Printf("%s: DTMissing; missing from tree2\n\n", relativePath)
-				s.countMissing++
-
-			case kDifferentPermissions:
-				fmt.Printf("%s: DTDiffPerms %s\n\n", relativePath, entry.description)
-				s.countDifferentPerms++
-
-			case kDifferentTypes:
-				fmt.Printf("%s: DTDiffTypes %s\n\n", relativePath, entry.description)
-				s.countDifferentTypes++
-
-			case kMismatch:
-				fmt.Printf("%s: DTMismatch %s\n\n", relativePath, entry.description)
-				s.countMismatch++
-
-			case kIgnored:
-				fmt.Printf("%s: DTIgnored\n\n", relativePath)
-				s.countIgnoredByUser++
-
-			case kDirSameEntries:
-				s.countDirSame++
-
-			case kDirDifferentEntries:
-				fmt.Printf("%s: DTDiffEntries\n", relativePath)
-				fmt.Print(entry.description)
-				fmt.Print("\n")
-				s.countDirDifferent++
-
-			default:
-				panic(fmt.Sprintf("Got result=%d for path %s", entry.result,
-					relativePath))
-			}
+			s.handleResult(entry, options)
 		}
 
-		// Recycle the treeEntry
+		// Recycle the treeEntry. This never blocks, even if the
+		// reader has stopped taking blank entries, as blankEntryChan
+		// can hold every treeEntry.
 		entry.reset()
 		blankEntryChan <- entry
 	}
+}
+
+// Write a line with the counts so far, for CountsInterval
+func (s *ComparisonEngine) writeCounts(options *DifftreeOptions, elapsed time.Duration) {
+	output := options.CountsOutput
+	if output == nil {
+		output = os.Stderr
+	}
+	fmt.Fprintf(output,
+		"%s: %d matches, %d mismatches, %d missing, %d extra, %d errors\n",
+		elapsed.Round(time.Second), s.stats.PerfectMatch, s.stats.Mismatch,
+		s.stats.Missing, s.stats.Extra, s.stats.Error)
+}
+
+// Count, and report, a single result
+func (s *ComparisonEngine) handleResult(entry *treeEntry, options *DifftreeOptions) {
+	if (options.DetectRenames || options.DetectCaseRenames) && s.collectRenameCandidates(entry) {
+		// This is reported after the walk, by reportRenames
+		return
+	}
+
+	// The entries only in dir1 are reported by the walk, and those
+	// only in dir2 are reported after the directory. The quick
+	// comparison doesn't know which entries they are.
+	expand := options.ExpandDirDiffs && entry.dir2Extra != nil &&
+		(entry.result == kDirDifferentEntries || entry.result == kDirDifferentDotfiles)
+	if expand {
+		entry.description = ""
+		defer s.reportExtraEntries(entry, options)
+	}
+
+	if options.Baseline != nil || options.WriteBaseline != nil {
+		s.applyBaseline(entry, options)
+	}
+
+	s.countResult(entry)
+
+	if entry.result.isDifference() {
+		s.foundDiff = true
+		if options.StopOnFirstDiff {
+			atomic.StoreInt32(&s.stopForDiff, 1)
+		}
+	}
+
+	if s.profile != nil {
+		s.profile.add(entry)
+	}
+
+	if s.duplicates != nil {
+		s.duplicates.add(entry)
+	}
+
+	if options.WriteMatched != nil {
+		s.keepMatched(entry)
+	}
 
+	if s.events != nil {
+		if entry.renderedEventValid() {
+			s.events.writeRendered(entry.renderedEvent)
+		} else {
+			s.events.writeResult(entry.toResult(s.relativePath(entry)))
+		}
+	}
+
+	if s.resultsDB != nil {
+		s.resultsDB.write(s.relativePath(entry), entry)
+	}
+
+	if options.RecordAllHashes {
+		s.writeHashRecord(entry, options)
+	}
+
+	switch entry.result {
+	case kPerfectMatch:
+		// Nothing to see here
+		logDebug("PerfectMatch: %s", entry.path1)
+	case kDirSameEntries, kDirSameSubtree, kNotSampled, kDirNotCompared, kBaselined,
+		kNotExecutable:
+	default:
+		if options.Quiet {
+			break
+		}
+		if s.treeView != nil {
+			s.treeView.add(entry.relPath, entry.result)
+			break
+		}
+		// Keep counting, but stop printing, after MaxReport
+		if options.MaxReport > 0 && s.numReported >= options.MaxReport {
+			s.numUnreported++
+		} else if options.GroupDirDiffs && isDirStructureDiff(entry.result) {
+			s.holdDirDiff(entry)
+			s.numReported++
+		} else {
+			s.printEntry(entry, options)
+			s.numReported++
+		}
+	}
+
+	if options.applies() {
+		s.applyFix(entry, options)
+	}
+}
+
+// Print a result in the OutputFormat
+func (s *ComparisonEngine) printEntry(entry *treeEntry, options *DifftreeOptions) {
+	switch options.OutputFormat {
+	case OutputGitHub:
+		s.printGitHubAnnotation(entry)
+	case OutputHTML:
+		s.addHTMLRow(entry, options)
+	case OutputItemize:
+		s.printItemized(entry)
+	default:
+		s.printResult(entry, options)
+	}
+}
+
+func (s *ComparisonEngine) relativePath(entry *treeEntry) string {
+	if entry.relPath != "" {
+		return entry.relPath
+	}
+	return entry.path1
+}
+
+func (s *ComparisonEngine) countResult(entry *treeEntry) {
+	if entry.sampled {
+		s.stats.Sampled++
+	}
+	if entry.hashVerified {
+		s.stats.HashVerified++
+	}
+	if entry.hashAssumed {
+		s.stats.HashAssumed++
+	}
+	if entry.skippedMatched {
+		s.stats.SkippedMatched++
+	}
+
+	if !s.stats.add(entry.result) {
+		panic(fmt.Sprintf("Got result=%d for path %s", entry.result,
+			s.relativePath(entry)))
+	}
+	if s.exitIgnores[entry.result] {
+		s.exitIgnored.add(entry.result)
+	}
+}
+
+// Count a result in its field. Returns false for an unknown result.
+func (self *Stats) add(result resultType) bool {
+	switch result {
+	case kPerfectMatch:
+		self.PerfectMatch++
+	case kError:
+		self.Error++
+	case kMissing:
+		self.Missing++
+	case kMoved:
+		self.Moved++
+	case kCaseRename:
+		self.CaseRenamed++
+	case kExtra:
+		self.Extra++
+	case kDifferentPermissions:
+		self.DifferentPerms++
+	case kAcceptedPerms:
+		self.AcceptedPerms++
+	case kDirMtimePrecision:
+		self.DirMtimePrecision++
+	case kDifferentTypes:
+		self.DifferentTypes++
+	case kDifferentContentType:
+		self.DifferentContentTypes++
+	case kDifferentOwner:
+		self.DifferentOwners++
+	case kDifferentXattrs:
+		self.DifferentXattrs++
+	case kDifferentBirthtime:
+		self.DifferentBirthtime++
+	case kDifferentProjectID:
+		self.DifferentProjectIDs++
+	case kDifferentGeneration:
+		self.DifferentGenerations++
+	case kDifferentTargets:
+		self.DifferentTargets++
+	case kSameResolvedContent:
+		self.SameResolvedContent++
+	case kDifferentResolvedContent:
+		self.DifferentResolvedContent++
+	case kEscapingSymlink:
+		self.EscapingSymlinks++
+	case kSuspiciousPerms:
+		self.SuspiciousPerms++
+	case kMismatch:
+		self.Mismatch++
+	case kTruncated:
+		self.Truncated++
+	case kNormalizedMatch:
+		self.NormalizedMatch++
+	case kIgnored:
+		self.IgnoredByUser++
+	case kNotSampled:
+		self.NotSampled++
+	case kMetadataDiffers:
+		self.MetadataDiffers++
+	case kBaselined:
+		self.Baselined++
+	case kDirNotCompared, kNotExecutable:
+	case kDirSameEntries:
+		self.DirSame++
+	case kDirSameSubtree:
+		self.DirSameSubtree++
+	case kDirDifferentEntries:
+		self.DirDifferent++
+	case kDirDifferentDotfiles:
+		self.DirDifferentDotfiles++
+	case kDirDifferentMtime:
+		self.DirDifferentMtime++
+	case kDirDifferentAttrs:
+		self.DirDifferentAttrs++
+	default:
+		return false
+	}
+	return true
+}
+
+func (s *ComparisonEngine) printResult(entry *treeEntry, options *DifftreeOptions) {
+	if entry.renderedTextValid() {
+		os.Stdout.Write(entry.renderedText)
+		return
+	}
+	s.writeResult(os.Stdout, entry, options)
+}
+
+// Write the text report of a single result
+func (s *ComparisonEngine) writeResult(writer io.Writer, entry *treeEntry,
+	options *DifftreeOptions) {
+
+	relativePath, description := s.displayText(entry, options)
+
+	switch entry.result {
+	case kError:
+		fmt.Fprintf(writer, "%s: DTError %v\n\n", relativePath, entry.err)
+
+	case kMissing:
+		fmt.Fprintf(writer, "%s: DTMissing; missing from tree2\n\n", relativePath)
+
+	case kExtra:
+		fmt.Fprintf(writer, "%s: DTExtra; missing from tree1\n\n", relativePath)
+
+	case kMoved:
+		fmt.Fprintf(writer, "%s: DTMoved %s\n\n", relativePath, description)
+
+	case kCaseRename:
+		fmt.Fprintf(writer, "%s: DTCaseRename %s\n\n", relativePath, description)
+
+	case kDifferentPermissions:
+		fmt.Fprintf(writer, "%s: DTDiffPerms %s\n\n", relativePath, description)
+
+	case kAcceptedPerms:
+		fmt.Fprintf(writer, "%s: DTAcceptedPerms %s\n\n", relativePath, description)
+
+	case kDirMtimePrecision:
+		fmt.Fprintf(writer, "%s: DTMtimePrecision %s\n\n", relativePath, description)
+
+	case kDifferentTypes:
+		fmt.Fprintf(writer, "%s: DTDiffTypes %s\n\n", relativePath, description)
+
+	case kDifferentContentType:
+		fmt.Fprintf(writer, "%s: DTDiffContentType %s\n\n", relativePath, description)
+
+	case kDifferentOwner:
+		fmt.Fprintf(writer, "%s: DTDiffOwner %s\n\n", relativePath, description)
+
+	case kDifferentXattrs:
+		fmt.Fprintf(writer, "%s: DTDiffXattrs %s\n\n", relativePath, description)
+
+	case kDifferentBirthtime:
+		fmt.Fprintf(writer, "%s: DTDiffBirthtime %s\n\n", relativePath, description)
+
+	case kDifferentProjectID:
+		fmt.Fprintf(writer, "%s: DTDiffProjectID %s\n\n", relativePath, description)
+
+	case kDifferentGeneration:
+		fmt.Fprintf(writer, "%s: DTDiffGeneration %s\n\n", relativePath, description)
+
+	case kMetadataDiffers:
+		fmt.Fprintf(writer, "%s: DTDiffMetadata %s\n\n", relativePath, description)
+
+	case kDifferentTargets:
+		fmt.Fprintf(writer, "%s: DTDiffTarget %s\n\n", relativePath, description)
+
+	case kSameResolvedContent:
+		fmt.Fprintf(writer, "%s: DTSameResolved %s\n\n", relativePath, description)
+
+	case kDifferentResolvedContent:
+		fmt.Fprintf(writer, "%s: DTDiffResolved %s\n\n", relativePath, description)
+
+	case kSuspiciousPerms:
+		fmt.Fprintf(writer, "%s: DTSuspiciousPerms %s\n\n", relativePath, description)
+
+	case kEscapingSymlink:
+		fmt.Fprintf(writer, "%s: DTEscapes %s\n\n", relativePath, description)
+
+	case kMismatch:
+		fmt.Fprintf(writer, "%s: DTMismatch %s\n\n", relativePath, description)
+
+	case kTruncated:
+		fmt.Fprintf(writer, "%s: DTTruncated %s\n\n", relativePath, description)
+
+	case kNormalizedMatch:
+		fmt.Fprintf(writer, "%s: DTNormalized %s\n\n", relativePath, description)
+
+	case kIgnored:
+		fmt.Fprintf(writer, "%s: DTIgnored\n\n", relativePath)
+
+	case kDirDifferentEntries:
+		fmt.Fprintf(writer, "%s: DTDiffEntries\n", relativePath)
+		fmt.Fprint(writer, description)
+		fmt.Fprint(writer, "\n")
+
+	case kDirDifferentDotfiles:
+		fmt.Fprintf(writer, "%s: DTDiffDotfiles\n", relativePath)
+		fmt.Fprint(writer, description)
+		fmt.Fprint(writer, "\n")
+
+	case kDirDifferentMtime:
+		fmt.Fprintf(writer, "%s: DTDiffDirMtime %s\n\n", relativePath, description)
+
+	case kDirDifferentAttrs:
+		fmt.Fprintf(writer, "%s: DTDiffEntryAttrs\n", relativePath)
+		fmt.Fprint(writer, description)
+	}
+}
+
+// Compare the top-level entries of the two roots, and report the
+// ones which are only in tree2.
+func (s *ComparisonEngine) reportRootExtras(path1 string, path2 string,
+	options *DifftreeOptions) error {
+
+	// The names are needed, not just the counts
+	rootOptions := *options
+	rootOptions.QuickDirCompare = false
+
+	root := treeEntry{path1: path1, path2: path2}
+	root.compareDirectories(&rootOptions)
+
+	switch root.result {
+	case kError:
+		return root.err
+	case kDirSameEntries:
+		return nil
+	}
+
+	s.reportExtraEntries(&root, options)
 	return nil
 }
+
+// Report each of the entries only in dir2 of a directory as kExtra, in
+// order of their names
+func (s *ComparisonEngine) reportExtraEntries(dir *treeEntry, options *DifftreeOptions) {
+	if dir.dir2Extra == nil || dir.dir2Extra.Cardinality() == 0 {
+		return
+	}
+
+	names := make([]string, 0, dir.dir2Extra.Cardinality())
+	for _, item := range dir.dir2Extra.ToSlice() {
+		names = append(names, item.(string))
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		extra := treeEntry{
+			path1:   filepath.Join(dir.path1, name),
+			path2:   filepath.Join(dir.path2, name),
+			relPath: filepath.Join(dir.relPath, name),
+			result:  kExtra,
+		}
+		s.handleResult(&extra, options)
+	}
+}
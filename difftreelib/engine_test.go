@@ -0,0 +1,264 @@
+package difftreelib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRootPrefixLen(t *testing.T) {
+	tests := []struct {
+		root string
+		path string
+		want string
+	}{
+		{"a", "a/file", "file"},
+		{"a/b", "a/b/c/file", "c/file"},
+		{"a/", "a/file", "file"},
+		{"/", "/file", "file"},
+		{"/a", "/a/file", "file"},
+	}
+	for _, test := range tests {
+		root := filepath.FromSlash(test.root)
+		path := filepath.FromSlash(test.path)
+		length := rootPrefixLen(root)
+		if length > len(path) {
+			t.Errorf("rootPrefixLen(%q) = %d, longer than %q", test.root, length, test.path)
+			continue
+		}
+		if got := filepath.ToSlash(path[length:]); got != test.want {
+			t.Errorf("%q under %q is %q, want %q", test.path, test.root, got, test.want)
+		}
+	}
+}
+
+func TestExcludesDir(t *testing.T) {
+	tests := []struct {
+		pattern string
+		relPath string
+		want    bool
+	}{
+		{"cache", "cache", true},
+		{"cache", "a/cache", true},
+		{"cache", "a/b/cache", true},
+		{"cache", "cache2", false},
+		{"cache", "a/mycache", false},
+		{"./cache", "cache", true},
+		{"./cache", "a/cache", false},
+		{"/cache", "cache", true},
+		{"/cache", "a/cache", false},
+		{"a/cache", "a/cache", true},
+		{"a/cache", "b/a/cache", true},
+		{"./a/cache", "b/a/cache", false},
+		{"cache/", "a/cache", true},
+	}
+	for _, test := range tests {
+		options := DifftreeOptions{ExcludeDirs: []string{test.pattern}}
+		got := options.excludesDir(filepath.FromSlash(test.relPath))
+		if got != test.want {
+			t.Errorf("ExcludeDirs %q excludes %q = %v, want %v", test.pattern,
+				test.relPath, got, test.want)
+		}
+	}
+}
+
+func TestExcludeDirsInWalk(t *testing.T) {
+	tree1 := testTree{
+		"cache/file":             "1",
+		"sub/cache/file":         "1",
+		"node_modules/file":      "1",
+		"sub/node_modules/file":  "1",
+		"files/cache":            "1",
+		"files/node_modules":     "1",
+		"files/cache2/file":      "1",
+		"files/not_node_modules": "1",
+	}
+	tree2 := make(testTree)
+	for name := range tree1 {
+		tree2[name] = "22"
+	}
+	path1, path2, cleanup := makeTrees(t, tree1, tree2)
+	defer cleanup()
+
+	tests := []struct {
+		name         string
+		excludeDirs  []string
+		wantMismatch int
+	}{
+		{"none", nil, 8},
+		{"anchored", []string{"./cache"}, 7},
+		{"name only", []string{"cache"}, 6},
+		{"both", []string{"./cache", "node_modules"}, 5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := DifftreeOptions{ExcludeDirs: test.excludeDirs}
+			stats := compareQuietly(t, path1, path2, &options)
+			if stats.Mismatch != test.wantMismatch {
+				t.Errorf("Mismatch = %d, want %d", stats.Mismatch, test.wantMismatch)
+			}
+		})
+	}
+}
+
+func TestSameRoot(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	testTree{
+		"tree/file":  "1",
+		"copy/file":  "1",
+		"link":       symlinkTo + "tree",
+		"tree/inner": symlinkTo + ".",
+	}.make(t, dir)
+	tree := filepath.Join(dir, "tree")
+
+	tests := []struct {
+		name  string
+		path1 string
+		path2 string
+		same  bool
+	}{
+		{"identical", tree, tree, true},
+		{"trailing slash", tree, tree + "/", true},
+		{"symlink", tree, filepath.Join(dir, "link"), true},
+		{"symlink inside", filepath.Join(dir, "link"), filepath.Join(tree, "inner"), true},
+		{"copy", tree, filepath.Join(dir, "copy"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, allow := range []bool{false, true} {
+				options := DifftreeOptions{Quiet: true, AllowSameRoot: allow}
+				var engine ComparisonEngine
+				err := engine.Compare(test.path1, test.path2, &options)
+				wantErr := test.same && !allow
+				if gotErr := err != nil; gotErr != wantErr {
+					t.Errorf("AllowSameRoot %v: Compare() = %v, want an error: %v",
+						allow, err, wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestDescendIntoDifferingDirs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows has no directory perms to differ")
+	}
+	path1, path2, cleanup := makeTrees(t,
+		testTree{"perms/file": "1", "type/file": "1"},
+		testTree{"perms/file": "22", "type": "not a directory"})
+	defer cleanup()
+	if err := os.Chmod(filepath.Join(path2, "perms"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		prune        bool
+		wantMismatch int
+	}{
+		// perms/file is still compared
+		{"descended", false, 1},
+		{"pruned", true, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := DifftreeOptions{PruneDifferingDirs: test.prune}
+			stats := compareQuietly(t, path1, path2, &options)
+			if stats.Mismatch != test.wantMismatch {
+				t.Errorf("Mismatch = %d, want %d", stats.Mismatch, test.wantMismatch)
+			}
+			if stats.DifferentPerms != 1 {
+				t.Errorf("DifferentPerms = %d, want 1", stats.DifferentPerms)
+			}
+			// Nothing in type is compared, either way
+			if stats.DifferentTypes != 1 || stats.Missing != 0 {
+				t.Errorf("DifferentTypes = %d, Missing = %d, want 1 and 0",
+					stats.DifferentTypes, stats.Missing)
+			}
+		})
+	}
+}
+
+func TestStructureOnly(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows has no perms to differ")
+	}
+	path1, path2, cleanup := makeTrees(t,
+		testTree{"contents": "1", "perms": "1", "missing": "1", "type": "1",
+			"dir/file": "1"},
+		testTree{"contents": "22", "perms": "1", "extra": "1", "type/": "",
+			"dir/file": "333"})
+	defer cleanup()
+	if err := os.Chmod(filepath.Join(path2, "perms"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		structure bool
+		want      Stats
+	}{
+		{"everything", false, Stats{Mismatch: 2, DifferentPerms: 1, Missing: 1,
+			DifferentTypes: 1, DirSame: 1, DirDifferent: 1}},
+		{"structure only", true, Stats{PerfectMatch: 3, Missing: 1,
+			DifferentTypes: 1, DirSame: 1, DirDifferent: 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := DifftreeOptions{StructureOnly: test.structure}
+			stats := compareQuietly(t, path1, path2, &options)
+			if stats != test.want {
+				t.Errorf("stats = %+v, want %+v", stats, test.want)
+			}
+		})
+	}
+}
+
+// Compare trees of 2000 files at each PipelineDepth, and with opening
+// each file taking a millisecond, as on a slow filesystem
+func BenchmarkPipelineDepth(b *testing.B) {
+	const numFiles = 2000
+	path1, path2, cleanup := makeBenchTrees(b, numFiles)
+	defer cleanup()
+
+	for _, delay := range []time.Duration{0, time.Millisecond} {
+		for _, depth := range []int{1, 4, 16} {
+			b.Run(fmt.Sprintf("depth %d, open takes %v", depth, delay), func(b *testing.B) {
+				if delay > 0 {
+					defer replaceOpenFile(slowOpenFile(delay))()
+				}
+				benchmarkCompare(b, path1, path2, numFiles,
+					DifftreeOptions{PipelineDepth: depth, Workers: 8})
+			})
+		}
+	}
+}
+
+// Compare trees of 2000 files, in 20 top-level directories, with one
+// walk and with PartitionTopLevel, and with opening each file taking a
+// millisecond, as on a slow filesystem
+func BenchmarkPartitionTopLevel(b *testing.B) {
+	const numFiles = 2000
+	path1, path2, cleanup := makeBenchTrees(b, numFiles)
+	defer cleanup()
+
+	for _, delay := range []time.Duration{0, time.Millisecond} {
+		for _, partition := range []bool{false, true} {
+			b.Run(fmt.Sprintf("partitioned %v, open takes %v", partition, delay),
+				func(b *testing.B) {
+					if delay > 0 {
+						defer replaceOpenFile(slowOpenFile(delay))()
+					}
+					benchmarkCompare(b, path1, path2, numFiles,
+						DifftreeOptions{PartitionTopLevel: partition, Workers: 8})
+				})
+		}
+	}
+}
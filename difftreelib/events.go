@@ -0,0 +1,126 @@
+package difftreelib
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// The events are written as JSON Lines, one object per line. Each
+// object has a "type" key, which is one of:
+//
+//	"result"   - one per processed path, with "path", "result",
+//	             and, if there is one, "description" and "error".
+//	             If any of those isn't valid UTF-8, they are all
+//	             escaped as escapeInvalidUTF8 describes, and
+//	             "escaped" is true.
+//	"progress" - at most once per progressInterval, with "processed",
+//	             the number of results so far, and "elapsed_seconds"
+//	"summary"  - the last event, with "stats", as in Stats
+const progressInterval = time.Second
+
+type resultEvent struct {
+	Type        string `json:"type"`
+	Path        string `json:"path"`
+	Result      string `json:"result"`
+	Description string `json:"description,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Escaped     bool   `json:"escaped,omitempty"`
+}
+
+type progressEvent struct {
+	Type           string  `json:"type"`
+	Processed      int     `json:"processed"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+}
+
+type summaryEvent struct {
+	Type  string `json:"type"`
+	Stats Stats  `json:"stats"`
+}
+
+type eventWriter struct {
+	writer       io.Writer
+	encoder      *json.Encoder
+	start        time.Time
+	lastProgress time.Time
+	processed    int
+}
+
+func newEventWriter(writer io.Writer) *eventWriter {
+	now := time.Now()
+	return &eventWriter{
+		writer:       writer,
+		encoder:      json.NewEncoder(writer),
+		start:        now,
+		lastProgress: now,
+	}
+}
+
+func (self *eventWriter) write(event interface{}) {
+	err := self.encoder.Encode(event)
+	if err != nil {
+		logError("Cannot write event: %v", err)
+	}
+}
+
+func newResultEvent(result Result) *resultEvent {
+	event := resultEvent{
+		Type:        "result",
+		Path:        result.Path,
+		Result:      result.Result,
+		Description: result.Description,
+	}
+	if result.Err != nil {
+		event.Error = result.Err.Error()
+	}
+	if !utf8.ValidString(event.Path) || !utf8.ValidString(event.Description) ||
+		!utf8.ValidString(event.Error) {
+		event.Path = escapeUTF8(event.Path)
+		event.Description = escapeUTF8(event.Description)
+		event.Error = escapeUTF8(event.Error)
+		event.Escaped = true
+	}
+	return &event
+}
+
+// Render a result event as writeResult would write it, with its newline
+func renderResultEvent(result Result) ([]byte, error) {
+	data, err := json.Marshal(newResultEvent(result))
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (self *eventWriter) writeResult(result Result) {
+	self.write(newResultEvent(result))
+	self.resultWritten()
+}
+
+// Write a result event which was rendered by renderResultEvent
+func (self *eventWriter) writeRendered(data []byte) {
+	if _, err := self.writer.Write(data); err != nil {
+		logError("Cannot write event: %v", err)
+	}
+	self.resultWritten()
+}
+
+// Count a result, and write a progress event if one is due
+func (self *eventWriter) resultWritten() {
+	self.processed++
+	now := time.Now()
+	if now.Sub(self.lastProgress) >= progressInterval {
+		self.lastProgress = now
+		self.write(&progressEvent{
+			Type:           "progress",
+			Processed:      self.processed,
+			ElapsedSeconds: now.Sub(self.start).Seconds(),
+		})
+	}
+}
+
+func (self *eventWriter) writeSummary(stats Stats) {
+	self.write(&summaryEvent{Type: "summary", Stats: stats})
+}
@@ -0,0 +1,68 @@
+package difftreelib
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// The default cap on the size of files copied by ExportDiffsDir
+const defaultExportSizeLimit = 10 << 20
+
+func (self *DifftreeOptions) exportSizeLimit() int64 {
+	if self.ExportSizeLimit > 0 {
+		return self.ExportSizeLimit
+	}
+	return defaultExportSizeLimit
+}
+
+// Copy both versions of a mismatched file into ExportDiffsDir, as
+// <relpath>.tree1 and <relpath>.tree2. A failure to export is logged,
+// but doesn't change the result of the comparison.
+func (self *treeEntry) exportDiff(options *DifftreeOptions) {
+	if self.result != kMismatch {
+		return
+	}
+	limit := options.exportSizeLimit()
+	if self.info1.Size() > limit || self.info2.Size() > limit {
+		logDebug("Not exporting %s: larger than %d bytes", self.path1, limit)
+		return
+	}
+
+	relPath := self.relPath
+	if relPath == "" {
+		relPath = filepath.Base(self.path1)
+	}
+	dest := filepath.Join(options.ExportDiffsDir, relPath)
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		logError("Not exporting %s: %v", self.path1, err)
+		return
+	}
+	if err := copyFile(self.path1, dest+".tree1"); err != nil {
+		logError("Not exporting %s: %v", self.path1, err)
+		return
+	}
+	if err := copyFile(self.path2, dest+".tree2"); err != nil {
+		logError("Not exporting %s: %v", self.path2, err)
+	}
+}
+
+func copyFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("copying %s to %s: %w", src, dest, err)
+	}
+	return out.Close()
+}
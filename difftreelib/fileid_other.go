@@ -0,0 +1,18 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package difftreelib
+
+import (
+	"os"
+)
+
+// The device and inode numbers are not available on this platform
+func deviceAndInode(info os.FileInfo) (uint64, uint64, bool) {
+	return 0, 0, false
+}
+
+// The owners of files are not available on this platform
+func fileOwner(info os.FileInfo) (uint32, uint32, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,59 @@
+package difftreelib
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// With OutputGitHub, each difference is printed as a workflow command:
+//
+//	::error file=tree1/a/b,title=DTMismatch::file1 has 10 bytes, ...
+//
+// Differences in metadata only are warnings, paths which were ignored,
+// matched after normalization, or had allowed permission changes, are
+// notices, and the rest are errors.
+func (s *ComparisonEngine) printGitHubAnnotation(entry *treeEntry) {
+	var level string
+	switch entry.result {
+	case kDifferentPermissions, kDifferentOwner, kDifferentXattrs,
+		kDifferentBirthtime, kDifferentProjectID, kDifferentGeneration,
+		kDirDifferentMtime, kMetadataDiffers, kSuspiciousPerms:
+		level = "warning"
+	case kIgnored, kNormalizedMatch, kAcceptedPerms, kDirMtimePrecision:
+		level = "notice"
+	default:
+		level = "error"
+	}
+
+	file := entry.path1
+	if file == "" {
+		file = s.relativePath(entry)
+	}
+
+	message := entry.description
+	if entry.result == kError {
+		message = fmt.Sprint(entry.err)
+	}
+	if message == "" {
+		message = entry.result.String()
+	}
+
+	fmt.Printf("::%s file=%s,title=%s::%s\n", level,
+		escapeGitHubProperty(filepath.ToSlash(file)),
+		escapeGitHubProperty(entry.result.String()),
+		escapeGitHubData(strings.TrimRight(message, "\n")))
+}
+
+var gitHubDataEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+
+var gitHubPropertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A",
+	":", "%3A", ",", "%2C")
+
+func escapeGitHubData(text string) string {
+	return gitHubDataEscaper.Replace(text)
+}
+
+func escapeGitHubProperty(text string) string {
+	return gitHubPropertyEscaper.Replace(text)
+}
@@ -0,0 +1,50 @@
+package difftreelib
+
+import (
+	"fmt"
+)
+
+// With RecordAllHashes, hash whichever of the two regular files
+// weren't hashed by the comparison, as when their sizes differed, or
+// one is missing.
+func (self *treeEntry) recordHashes(options *DifftreeOptions) {
+	if self.hash1 == nil && self.info1 != nil && self.info1.Mode().IsRegular() {
+		hash, err := getFileHash(self.path1, options)
+		if err != nil {
+			logError("Cannot record the hash of %s: %v", self.path1, err)
+		} else {
+			self.hash1 = hash
+			self.bytesRead += self.info1.Size()
+		}
+	}
+	if self.hash2 == nil && self.hasInfo2 && self.info2.Mode().IsRegular() {
+		hash, err := getFileHash(self.path2, options)
+		if err != nil {
+			logError("Cannot record the hash of %s: %v", self.path2, err)
+		} else {
+			self.hash2 = hash
+			self.bytesRead += self.info2.Size()
+		}
+	}
+}
+
+// Write a line to HashRecord for a regular file, with tab-separated
+// fields: the relative path, the hashes in tree1 and tree2, in hex,
+// or "-" for a file which isn't there, and the result tag.
+func (s *ComparisonEngine) writeHashRecord(entry *treeEntry, options *DifftreeOptions) {
+	if entry.hash1 == nil && entry.hash2 == nil {
+		return
+	}
+	_, err := fmt.Fprintf(options.HashRecord, "%s\t%s\t%s\t%s\n", s.relativePath(entry),
+		hexOrDash(entry.hash1), hexOrDash(entry.hash2), entry.result)
+	if err != nil {
+		logError("Cannot write the hash record: %v", err)
+	}
+}
+
+func hexOrDash(hash []byte) string {
+	if hash == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%x", hash)
+}
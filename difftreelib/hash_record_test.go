@@ -0,0 +1,44 @@
+package difftreelib
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The hash record of a file large enough to be hashed in segments has
+// its BLAKE3 digest, which VerifyManifest accepts
+func TestHashRecordOfLargeFile(t *testing.T) {
+	path1, path2, cleanup := makeTrees(t, testTree{}, testTree{})
+	defer cleanup()
+	for _, path := range []string{path1, path2} {
+		writeRandomFile(t, filepath.Join(path, "large"), parallelHashThreshold)
+	}
+
+	var record bytes.Buffer
+	options := DifftreeOptions{CheckHashes: true, HashAlgorithm: HashBLAKE3,
+		RecordAllHashes: true, HashRecord: &record}
+	compareQuietly(t, path1, path2, &options)
+
+	fields := strings.Split(strings.TrimSpace(record.String()), "\t")
+	if len(fields) != 4 {
+		t.Fatalf("The record is %q", record.String())
+	}
+	want := hex.EncodeToString(hashSingleStreamBLAKE3(t, filepath.Join(path1, "large")))
+	if fields[1] != want || fields[2] != want {
+		t.Errorf("Recorded %s and %s, want %s", fields[1], fields[2], want)
+	}
+
+	manifest := fmt.Sprintf("BLAKE3 (large) = %s\n", fields[1])
+	verifyOptions := DifftreeOptions{Quiet: true}
+	var engine ComparisonEngine
+	if err := engine.VerifyManifest(strings.NewReader(manifest), path2, &verifyOptions); err != nil {
+		t.Fatal(err)
+	}
+	if stats := engine.Stats(); stats.PerfectMatch != 1 {
+		t.Errorf("VerifyManifest: %+v", stats)
+	}
+}
@@ -0,0 +1,188 @@
+package difftreelib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	// Only what went wrong
+	SetLogLevel(LogError)
+}
+
+// The prefix of a value in a testTree which makes a symlink to the rest
+const symlinkTo = "-> "
+
+// Files to make under a root, by their slash-separated paths relative
+// to it. A path ending in "/" is a directory, and a value starting with
+// symlinkTo is a symlink; any other value is the contents of a regular
+// file. Parents are made as needed.
+type testTree map[string]string
+
+func (self testTree) make(t testing.TB, root string) {
+	t.Helper()
+	if err := self.makeOrError(root); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (self testTree) makeOrError(root string) error {
+	for relPath, value := range self {
+		path := filepath.Join(root, filepath.FromSlash(relPath))
+		if strings.HasSuffix(relPath, "/") {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		var err error
+		if strings.HasPrefix(value, symlinkTo) {
+			err = os.Symlink(filepath.FromSlash(value[len(symlinkTo):]), path)
+		} else {
+			err = ioutil.WriteFile(path, []byte(value), 0644)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A temporary directory, and a func which removes it
+func tempDir(t testing.TB) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "difftree-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// Make the two trees, as "a" and "b" in a temporary directory, and
+// return a func which removes them
+func makeTrees(t testing.TB, tree1 testTree, tree2 testTree) (string, string, func()) {
+	t.Helper()
+	dir, cleanup := tempDir(t)
+	path1 := filepath.Join(dir, "a")
+	path2 := filepath.Join(dir, "b")
+	for _, path := range []string{path1, path2} {
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree1.make(t, path1)
+	tree2.make(t, path2)
+	return path1, path2, cleanup
+}
+
+// Compare the trees, with nothing printed, and return the counts
+func compareQuietly(t testing.TB, path1 string, path2 string,
+	options *DifftreeOptions) Stats {
+
+	t.Helper()
+	options.Quiet = true
+	var engine ComparisonEngine
+	if err := engine.Compare(path1, path2, options); err != nil {
+		t.Fatalf("Compare: %v", err)
+	}
+	return engine.Stats()
+}
+
+// Run f, and return what it printed to stdout
+func captureStdout(t testing.TB, f func()) string {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	output := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(reader)
+		output <- data
+	}()
+
+	defer func() {
+		os.Stdout = stdout
+	}()
+	f()
+	writer.Close()
+	return string(<-output)
+}
+
+// An os.FileInfo with only a mode, and a size, for what can't be made
+// on the filesystem the tests run on
+type fakeFileInfo struct {
+	name string
+	mode os.FileMode
+	size int64
+}
+
+func (self fakeFileInfo) Name() string       { return self.name }
+func (self fakeFileInfo) Size() int64        { return self.size }
+func (self fakeFileInfo) Mode() os.FileMode  { return self.mode }
+func (self fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (self fakeFileInfo) IsDir() bool        { return self.mode.IsDir() }
+func (self fakeFileInfo) Sys() interface{}   { return nil }
+
+// Make two trees of numFiles small files, in directories of 100, whose
+// contents differ in every tenth file, but not their sizes, for
+// benchmarks
+func makeBenchTrees(b *testing.B, numFiles int) (string, string, func()) {
+	b.Helper()
+	tree1 := make(testTree, numFiles)
+	tree2 := make(testTree, numFiles)
+	for i := 0; i < numFiles; i++ {
+		name := fmt.Sprintf("dir%04d/file%06d", i/100, i)
+		tree1[name] = name
+		if i%10 == 0 {
+			tree2[name] = strings.ToUpper(name)
+		} else {
+			tree2[name] = name
+		}
+	}
+	return makeTrees(b, tree1, tree2)
+}
+
+// Compare trees made by makeBenchTrees b.N times, hashing every file,
+// and fail unless every tenth file was a mismatch and the rest matched
+func benchmarkCompare(b *testing.B, path1 string, path2 string, numFiles int,
+	options DifftreeOptions) {
+
+	b.Helper()
+	options.CheckHashes = true
+	for i := 0; i < b.N; i++ {
+		options := options
+		stats := compareQuietly(b, path1, path2, &options)
+		if stats.Mismatch != numFiles/10 || stats.PerfectMatch != numFiles-numFiles/10 {
+			b.Fatalf("%d mismatches and %d perfect matches, want %d and %d",
+				stats.Mismatch, stats.PerfectMatch, numFiles/10, numFiles-numFiles/10)
+		}
+	}
+}
+
+// Replace openFile with open, until the returned func is called
+func replaceOpenFile(open func(string) (*os.File, error)) func() {
+	saved := openFile
+	openFile = open
+	return func() {
+		openFile = saved
+	}
+}
+
+// An openFile which takes delay longer, like a slow filesystem
+func slowOpenFile(delay time.Duration) func(string) (*os.File, error) {
+	return func(name string) (*os.File, error) {
+		time.Sleep(delay)
+		return os.Open(name)
+	}
+}
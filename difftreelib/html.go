@@ -0,0 +1,150 @@
+package difftreelib
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// With OutputHTML, the differences are kept, and written at the end as
+// a standalone page, with the counts, and a table of the differences
+// which can be sorted by clicking a column, and filtered by result or
+// by any text.
+type htmlReport struct {
+	title string
+	rows  []htmlRow
+}
+
+type htmlRow struct {
+	Path        string
+	Result      string
+	Description string
+}
+
+type htmlCount struct {
+	Name  string
+	Count int64
+}
+
+func (s *ComparisonEngine) addHTMLRow(entry *treeEntry, options *DifftreeOptions) {
+	relativePath, description := s.displayText(entry, options)
+	if entry.result == kError {
+		description = fmt.Sprint(entry.err)
+	}
+	s.htmlReport.rows = append(s.htmlReport.rows, htmlRow{
+		Path:        relativePath,
+		Result:      entry.result.String(),
+		Description: strings.TrimRight(description, "\n"),
+	})
+}
+
+// The counts which aren't zero, by their JSON keys
+func (self Stats) nonZeroCounts() []htmlCount {
+	var counts []htmlCount
+	statsValue := reflect.ValueOf(self)
+	statsType := statsValue.Type()
+	for i := 0; i < statsType.NumField(); i++ {
+		value := statsValue.Field(i)
+		if value.Kind() != reflect.Int || value.Int() == 0 {
+			continue
+		}
+		key := strings.Split(statsType.Field(i).Tag.Get("json"), ",")[0]
+		counts = append(counts, htmlCount{
+			Name:  strings.Replace(key, "_", " ", -1),
+			Count: value.Int(),
+		})
+	}
+	return counts
+}
+
+func (s *ComparisonEngine) writeHTMLReport(writer io.Writer) error {
+	var results []string
+	seen := make(map[string]bool)
+	for _, row := range s.htmlReport.rows {
+		if !seen[row.Result] {
+			seen[row.Result] = true
+			results = append(results, row.Result)
+		}
+	}
+
+	return htmlTemplate.Execute(writer, map[string]interface{}{
+		"Title":         s.htmlReport.title,
+		"Generated":     time.Now().Format(time.RFC1123),
+		"Severity":      s.stats.Severity().String(),
+		"Incomplete":    s.stats.IncompleteReason,
+		"Counts":        s.stats.nonZeroCounts(),
+		"Results":       results,
+		"Rows":          s.htmlReport.rows,
+		"NumUnreported": s.numUnreported,
+	})
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>difftree: {{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+th { background: #eee; }
+#results th { cursor: pointer; }
+#results td:nth-child(3) { white-space: pre-wrap; font-family: monospace; }
+.warning { color: #a00; }
+</style>
+</head>
+<body>
+<h1>difftree: {{.Title}}</h1>
+<p>Generated {{.Generated}}. Severity: <b>{{.Severity}}</b></p>
+{{if .Incomplete}}<p class="warning">INCOMPLETE: {{.Incomplete}} before all of the paths were compared</p>{{end}}
+<h2>Summary</h2>
+<table>
+{{range .Counts}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+<h2>Differences</h2>
+{{if .NumUnreported}}<p class="warning">{{.NumUnreported}} more differences are not listed</p>{{end}}
+<p>
+<select id="result" onchange="filterRows()">
+<option value="">All results</option>
+{{range .Results}}<option>{{.}}</option>
+{{end}}</select>
+<input id="text" type="search" placeholder="Filter" oninput="filterRows()">
+</p>
+<table id="results">
+<thead><tr><th onclick="sortRows(0)">Path</th><th onclick="sortRows(1)">Result</th><th onclick="sortRows(2)">Description</th></tr></thead>
+<tbody>
+{{range .Rows}}<tr><td>{{.Path}}</td><td>{{.Result}}</td><td>{{.Description}}</td></tr>
+{{end}}</tbody>
+</table>
+<script>
+var sortColumn = -1, sortAscending = true;
+function sortRows(column) {
+  sortAscending = column === sortColumn ? !sortAscending : true;
+  sortColumn = column;
+  var body = document.querySelector("#results tbody");
+  var rows = Array.prototype.slice.call(body.rows);
+  rows.sort(function(a, b) {
+    var x = a.cells[column].textContent, y = b.cells[column].textContent;
+    return (x < y ? -1 : x > y ? 1 : 0) * (sortAscending ? 1 : -1);
+  });
+  rows.forEach(function(row) { body.appendChild(row); });
+}
+function filterRows() {
+  var result = document.getElementById("result").value;
+  var text = document.getElementById("text").value.toLowerCase();
+  var rows = document.querySelectorAll("#results tbody tr");
+  for (var i = 0; i < rows.length; i++) {
+    var row = rows[i];
+    var show = (result === "" || row.cells[1].textContent === result) &&
+      row.textContent.toLowerCase().indexOf(text) !== -1;
+    row.style.display = show ? "" : "none";
+  }
+}
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,30 @@
+package difftreelib
+
+import "fmt"
+
+// Whether the two trees are byte-for-byte identical, for scripts which
+// only need a yes or no. This is Compare, with CheckHashes and
+// StopOnFirstDiff, and Quiet, so nothing is printed; the other options
+// apply as given. It returns false as soon as a difference is found,
+// including an error reading a path, without comparing the rest. A
+// comparison which stops early for any other reason, as for Deadline,
+// is an error, as it can't tell.
+func Identical(path1 string, path2 string, options *DifftreeOptions) (bool, error) {
+	identicalOptions := *options
+	identicalOptions.CheckHashes = true
+	identicalOptions.StopOnFirstDiff = true
+	identicalOptions.Quiet = true
+
+	var engine ComparisonEngine
+	if err := engine.Compare(path1, path2, &identicalOptions); err != nil {
+		return false, err
+	}
+	if engine.foundDiff {
+		return false, nil
+	}
+	if engine.stats.Incomplete {
+		return false, fmt.Errorf("Cannot tell if %s and %s are identical: %s",
+			path1, path2, engine.stats.IncompleteReason)
+	}
+	return true, nil
+}
@@ -0,0 +1,35 @@
+package difftreelib
+
+import (
+	"fmt"
+)
+
+// Returns true if the inode generation numbers differ, which they do
+// when an inode number has been reused for another file. If either
+// can't be read, they are not compared.
+func (self *treeEntry) compareInodeGenerations(options *DifftreeOptions) (metadataDiff, bool) {
+	// Reading a generation means opening the path, which isn't done
+	// for symlinks, devices or pipes
+	if !self.info1.Mode().IsRegular() && !self.info1.IsDir() {
+		return metadataDiff{}, false
+	}
+
+	gen1, ok1, err := getInodeGeneration(self.path1)
+	if err != nil {
+		logError("Cannot read the inode generation of %s: %v", self.path1, err)
+		return metadataDiff{}, false
+	}
+	gen2, ok2, err := getInodeGeneration(self.path2)
+	if err != nil {
+		logError("Cannot read the inode generation of %s: %v", self.path2, err)
+		return metadataDiff{}, false
+	}
+	if !ok1 || !ok2 || gen1 == gen2 {
+		return metadataDiff{}, false
+	}
+
+	return metadataDiff{
+		result:      kDifferentGeneration,
+		description: fmt.Sprintf("file1 has inode generation %d, file2 has %d", gen1, gen2),
+	}, true
+}
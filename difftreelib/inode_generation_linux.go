@@ -0,0 +1,40 @@
+//go:build linux && (386 || amd64 || arm || arm64 || riscv64 || s390x)
+// +build linux
+// +build 386 amd64 arm arm64 riscv64 s390x
+
+package difftreelib
+
+import (
+	"errors"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// _IOR('v', 1, long), on the architectures above, which share the
+// generic ioctl encoding; its size is that of a long
+const fsIocGetVersion = 0x80007601 | unsafe.Sizeof(uintptr(0))<<16
+
+// The generation number of a regular file or directory's inode, as
+// ext4 and XFS record it. Returns false if the filesystem has none.
+func getInodeGeneration(path string) (uint32, bool, error) {
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return 0, false, err
+	}
+	defer unix.Close(fd)
+
+	// Though the ioctl is declared with a long, the filesystems write
+	// an int
+	var generation uint32
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), fsIocGetVersion,
+		uintptr(unsafe.Pointer(&generation)))
+	if errno != 0 {
+		if errors.Is(errno, unix.ENOTTY) || errors.Is(errno, unix.ENOTSUP) ||
+			errors.Is(errno, unix.EINVAL) {
+			return 0, false, nil
+		}
+		return 0, false, errno
+	}
+	return generation, true, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux || !(386 || amd64 || arm || arm64 || riscv64 || s390x)
+// +build !linux !386,!amd64,!arm,!arm64,!riscv64,!s390x
+
+package difftreelib
+
+// Inode generation numbers are not available on this platform
+func getInodeGeneration(path string) (uint32, bool, error) {
+	return 0, false, nil
+}
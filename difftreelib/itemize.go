@@ -0,0 +1,159 @@
+package difftreelib
+
+import (
+	"fmt"
+	"os"
+)
+
+// With OutputItemize, each difference is printed as rsync's
+// --itemize-changes would print the change which would make tree2
+// like tree1, as from "rsync -a tree1/ tree2/":
+//
+//	YXcstpoguax path
+//
+// Y is ">" for a regular file whose contents would be sent, "c" for
+// any other path which would be created or changed, or "." for a path
+// with only its attributes changed. X is the type: "f" for a regular
+// file, "d" directory, "L" symlink, "D" device, "S" fifo or socket.
+// A path only in tree1 has "+" for the rest, like ">f+++++++++".
+// Otherwise each is the letter, if that differs, or ".":
+//
+//	c  contents, if they were compared, not only their sizes; or a
+//	   symlink's target
+//	s  size, of regular files
+//	t  modification time
+//	p  permissions
+//	o  owner
+//	g  group
+//	u  "n" for the creation time, with CheckBirthtime
+//	a  never; ACLs aren't compared
+//	x  extended attributes, with CheckXattrs
+//
+// A path only in tree2 is "*deleting", padded to 11 characters.
+// Directories' paths end in "/". Paths of different types are itemized
+// as created, with the type in tree1. Errors are printed to stderr.
+// Results which aren't differences between the two paths, like
+// symlinks escaping their tree, and those of directories whose
+// entries differ, aren't printed; use ExpandDirDiffs, so that the
+// entries only in tree2 are printed as deletions. Moves aren't
+// itemized; with DetectRenames, the files which were moved are left
+// out. VerifyManifest and VerifyArchive have no tree1 to itemize, so
+// they don't allow OutputItemize.
+func (s *ComparisonEngine) printItemized(entry *treeEntry) {
+	relativePath := s.relativePath(entry)
+
+	// An entry made after the walk, as for DetectRenames, may have no
+	// info1
+	info1 := entry.info1
+	if info1 == nil && (entry.result == kMissing || entry.result == kDifferentTypes) {
+		var err error
+		info1, err = os.Lstat(entry.path1)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", relativePath, err)
+			return
+		}
+	}
+
+	var flags string
+	switch entry.result {
+	case kError:
+		fmt.Fprintf(os.Stderr, "%s: %v\n", relativePath, entry.err)
+		return
+	case kExtra:
+		flags = "*deleting"
+	case kMissing, kDifferentTypes:
+		flags = itemizeCreated(info1.Mode())
+	case kDirDifferentEntries, kDirDifferentDotfiles, kDirDifferentAttrs,
+		kMoved, kCaseRename, kEscapingSymlink, kSuspiciousPerms:
+		return
+	default:
+		if !entry.result.isDifference() || entry.info1 == nil || entry.info2 == nil {
+			return
+		}
+		flags = itemizeChanged(entry)
+	}
+
+	if info1 != nil && info1.IsDir() ||
+		entry.result == kExtra && entry.info2 != nil && entry.info2.IsDir() {
+		relativePath += "/"
+	}
+	fmt.Printf("%-11s %s\n", flags, relativePath)
+}
+
+func itemizeType(mode os.FileMode) byte {
+	switch {
+	case mode.IsDir():
+		return 'd'
+	case mode&os.ModeSymlink != 0:
+		return 'L'
+	case mode&os.ModeDevice != 0:
+		return 'D'
+	case mode&(os.ModeNamedPipe|os.ModeSocket) != 0:
+		return 'S'
+	default:
+		return 'f'
+	}
+}
+
+func itemizeCreated(mode os.FileMode) string {
+	update := byte('c')
+	if mode.IsRegular() {
+		update = '>'
+	}
+	return string([]byte{update, itemizeType(mode)}) + "+++++++++"
+}
+
+func itemizeChanged(entry *treeEntry) string {
+	info1 := entry.info1
+	info2 := entry.info2
+	flags := []byte("...........")
+	flags[1] = itemizeType(info1.Mode())
+
+	// Like rsync without --checksum, a regular file of another size
+	// is sent, but only contents which were compared are flagged
+	contentsDiffer := false
+	switch entry.result {
+	case kMismatch, kTruncated, kNormalizedMatch, kDifferentContentType,
+		kDifferentTargets, kDifferentResolvedContent:
+		contentsDiffer = true
+	}
+	sizesDiffer := info1.Mode().IsRegular() && info2.Mode().IsRegular() &&
+		info1.Size() != info2.Size()
+
+	if contentsDiffer && (!sizesDiffer || entry.hash1 != nil) {
+		flags[2] = 'c'
+	}
+	if sizesDiffer {
+		flags[3] = 's'
+	}
+	if contentsDiffer || sizesDiffer {
+		if info1.Mode().IsRegular() {
+			flags[0] = '>'
+		} else {
+			flags[0] = 'c'
+		}
+	}
+	if !info1.ModTime().Equal(info2.ModTime()) {
+		flags[4] = 't'
+	}
+	if unixPerms(info1.Mode()) != unixPerms(info2.Mode()) {
+		flags[5] = 'p'
+	}
+	uid1, gid1, ok1 := fileOwner(info1)
+	uid2, gid2, ok2 := fileOwner(info2)
+	if ok1 && ok2 {
+		if uid1 != uid2 {
+			flags[6] = 'o'
+		}
+		if gid1 != gid2 {
+			flags[7] = 'g'
+		}
+	}
+	if entry.result == kDifferentBirthtime {
+		flags[8] = 'n'
+	}
+	if entry.result == kDifferentXattrs {
+		flags[10] = 'x'
+	}
+	return string(flags)
+}
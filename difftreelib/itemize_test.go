@@ -0,0 +1,98 @@
+package difftreelib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestItemizeWithDetectRenames(t *testing.T) {
+	path1, path2, cleanup := makeTrees(t,
+		testTree{
+			"onlyin1": "only in tree1",
+			"old":     "moved",
+			"same":    "same",
+		},
+		testTree{
+			"new":  "moved",
+			"same": "same",
+		})
+	defer cleanup()
+
+	options := DifftreeOptions{
+		OutputFormat:  OutputItemize,
+		DetectRenames: true,
+	}
+	var engine ComparisonEngine
+	var err error
+	output := captureStdout(t, func() {
+		err = engine.Compare(path1, path2, &options)
+	})
+	if err != nil {
+		t.Fatalf("Compare: %v", err)
+	}
+
+	want := ">f+++++++++ onlyin1\n"
+	if output != want {
+		t.Errorf("output = %q, want %q", output, want)
+	}
+}
+
+func TestItemizeRejectedForVerify(t *testing.T) {
+	root, cleanup := tempDir(t)
+	defer cleanup()
+	options := DifftreeOptions{OutputFormat: OutputItemize}
+
+	var engine ComparisonEngine
+	err := engine.VerifyManifest(strings.NewReader(""), root, &options)
+	if err == nil {
+		t.Error("VerifyManifest with OutputItemize succeeded")
+	}
+	err = engine.VerifyArchive("archive.tar", root, &options)
+	if err == nil {
+		t.Error("VerifyArchive with OutputItemize succeeded")
+	}
+}
+
+func TestItemizeChanged(t *testing.T) {
+	path1, path2, cleanup := makeTrees(t,
+		testTree{"size": "longer", "contents": "aaaa"},
+		testTree{"size": "short", "contents": "bbbb"})
+	defer cleanup()
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, path := range []string{path1, path2} {
+		for _, name := range []string{"size", "contents"} {
+			if err := os.Chtimes(filepath.Join(path, name), mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name        string
+		checkHashes bool
+		want        string
+	}{
+		{"sizes", false, ">f.s....... size\n"},
+		{"contents", true, ">fc........ contents\n>f.s....... size\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := DifftreeOptions{
+				OutputFormat: OutputItemize,
+				CheckHashes:  test.checkHashes,
+			}
+			var engine ComparisonEngine
+			output := captureStdout(t, func() {
+				if err := engine.Compare(path1, path2, &options); err != nil {
+					t.Errorf("Compare: %v", err)
+				}
+			})
+			if output != test.want {
+				t.Errorf("output = %q, want %q", output, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package difftreelib
+
+import (
+	"os"
+)
+
+// Only Windows has junctions
+func isJunction(info os.FileInfo) bool {
+	return false
+}
@@ -0,0 +1,20 @@
+package difftreelib
+
+import (
+	"os"
+	"syscall"
+)
+
+// Whether the path is a directory junction, or another reparse point
+// which Go reports as irregular rather than as a symlink. os.Readlink
+// returns the target of a junction as it does for a symlink.
+func isJunction(info os.FileInfo) bool {
+	if info.Mode()&os.ModeIrregular == 0 {
+		return false
+	}
+	attrs, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return false
+	}
+	return attrs.FileAttributes&syscall.FILE_ATTRIBUTE_REPARSE_POINT != 0
+}
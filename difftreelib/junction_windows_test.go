@@ -0,0 +1,81 @@
+package difftreelib
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// Make a directory junction, as symlinks need privileges which tests
+// don't usually have
+func makeJunction(t *testing.T, link string, target string) {
+	t.Helper()
+	output, err := exec.Command("cmd", "/c", "mklink", "/J", link, target).CombinedOutput()
+	if err != nil {
+		t.Skipf("Cannot make a junction: %v: %s", err, output)
+	}
+}
+
+func TestJunctions(t *testing.T) {
+	path1, path2, cleanup := makeTrees(t,
+		testTree{"target/file": "1", "other/file": "2"},
+		testTree{"target/file": "1", "other/file": "2"})
+	defer cleanup()
+
+	tests := []struct {
+		name           string
+		target1        string
+		target2        string
+		wantPerfect    int
+		wantDifferents int
+	}{
+		{"same targets", "target", "target", 3, 0},
+		{"different targets", "target", "other", 2, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			junction1 := filepath.Join(path1, "junction")
+			junction2 := filepath.Join(path2, "junction")
+			makeJunction(t, junction1, filepath.Join(path1, test.target1))
+			makeJunction(t, junction2, filepath.Join(path2, test.target2))
+			defer os.Remove(junction1)
+			defer os.Remove(junction2)
+
+			info, err := os.Lstat(junction1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !isLink(info) {
+				t.Errorf("a junction, with mode %v, isn't compared as a link", info.Mode())
+			}
+
+			// Junctions to the same place in each tree have different
+			// targets, as they are absolute; they are compared by the
+			// targets resolved within their trees
+			options := DifftreeOptions{ResolveSymlinkTargets: true}
+			stats := compareQuietly(t, path1, path2, &options)
+			if stats.DifferentTargets != test.wantDifferents {
+				t.Errorf("DifferentTargets = %d, want %d", stats.DifferentTargets,
+					test.wantDifferents)
+			}
+			// The files aren't compared again by way of the junctions
+			if stats.PerfectMatch != test.wantPerfect {
+				t.Errorf("PerfectMatch = %d, want %d", stats.PerfectMatch,
+					test.wantPerfect)
+			}
+		})
+	}
+}
+
+func TestDirectoryIsNotJunction(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	info, err := os.Lstat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isJunction(info) {
+		t.Errorf("%s is a junction", dir)
+	}
+}
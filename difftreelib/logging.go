@@ -0,0 +1,68 @@
+package difftreelib
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// How much is logged, through the standard log package, whose output
+// and flags are for the caller to set
+type LogLevel int
+
+const (
+	// Only what went wrong, but didn't stop the comparison
+	LogError LogLevel = iota
+
+	// And what was decided, like the number of workers, or a fix
+	// which was applied
+	LogInfo
+
+	// And a line for each path, as it is walked and compared
+	LogDebug
+)
+
+var logLevelNames = map[string]LogLevel{
+	"error": LogError,
+	"info":  LogInfo,
+	"debug": LogDebug,
+}
+
+// Set once, before comparing
+var logLevel = LogInfo
+
+func SetLogLevel(level LogLevel) {
+	logLevel = level
+}
+
+// Parse "error", "info" or "debug"
+func ParseLogLevel(name string) (LogLevel, error) {
+	level, ok := logLevelNames[strings.ToLower(name)]
+	if !ok {
+		return LogError, fmt.Errorf("Unknown log level %q; must be error, info or debug", name)
+	}
+	return level, nil
+}
+
+// The depth of the caller of logError, and the others, for
+// log.Lshortfile
+const logCallDepth = 3
+
+func logAt(level LogLevel, prefix string, format string, args ...interface{}) {
+	if level > logLevel {
+		return
+	}
+	log.Output(logCallDepth, prefix+fmt.Sprintf(format, args...))
+}
+
+func logError(format string, args ...interface{}) {
+	logAt(LogError, "ERROR: ", format, args...)
+}
+
+func logInfo(format string, args ...interface{}) {
+	logAt(LogInfo, "INFO: ", format, args...)
+}
+
+func logDebug(format string, args ...interface{}) {
+	logAt(LogDebug, "DEBUG: ", format, args...)
+}
@@ -0,0 +1,261 @@
+package difftreelib
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"lukechampine.com/blake3"
+)
+
+// A checksum manifest has a line per file, in either of the formats
+// of the coreutils checksum tools:
+//
+//	<hex>  <path>              (as written by sha256sum)
+//	SHA256 (<path>) = <hex>    (as written by sha256sum --tag, or BSD)
+//
+// The first format doesn't name the hash, so it is guessed from the
+// length of the hex: MD5, SHA1, SHA224, SHA256, SHA384 or SHA512.
+// The format is detected for each line.
+var (
+	taggedManifestLine = regexp.MustCompile(`^([A-Za-z0-9-]+) ?\((.*)\) = ([0-9a-fA-F]+)$`)
+	plainManifestLine  = regexp.MustCompile(`^([0-9a-fA-F]+) [ *](.*)$`)
+)
+
+var manifestHashes = map[string]func() hash.Hash{
+	"MD5":    md5.New,
+	"SHA1":   sha1.New,
+	"SHA224": sha256.New224,
+	"SHA256": sha256.New,
+	"SHA384": sha512.New384,
+	"SHA512": sha512.New,
+	"BLAKE3": func() hash.Hash { return blake3.New(blake3DigestSize, nil) },
+}
+
+// For untagged lines, the hash with each length of hex digest
+var manifestHashesByLength = map[int]string{
+	32:  "MD5",
+	40:  "SHA1",
+	56:  "SHA224",
+	64:  "SHA256",
+	96:  "SHA384",
+	128: "SHA512",
+}
+
+type manifestEntry struct {
+	path      string
+	algorithm string
+	hash      []byte
+}
+
+func readManifest(reader io.Reader) ([]manifestEntry, error) {
+	var entries []manifestEntry
+
+	scanner := bufio.NewScanner(reader)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry, err := parseManifestLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d of the manifest: %w", lineNum, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Reading the manifest: %w", err)
+	}
+	return entries, nil
+}
+
+func parseManifestLine(line string) (manifestEntry, error) {
+	// The coreutils tools start a line with a backslash when they
+	// escape a backslash or newline in the path
+	escaped := strings.HasPrefix(line, "\\")
+	if escaped {
+		line = line[1:]
+	}
+
+	var entry manifestEntry
+	var hexHash string
+	if match := taggedManifestLine.FindStringSubmatch(line); match != nil {
+		entry.algorithm = strings.ToUpper(match[1])
+		entry.path = match[2]
+		hexHash = match[3]
+	} else if match := plainManifestLine.FindStringSubmatch(line); match != nil {
+		hexHash = match[1]
+		entry.path = match[2]
+		entry.algorithm = manifestHashesByLength[len(hexHash)]
+		if entry.algorithm == "" {
+			return entry, fmt.Errorf("No hash has %d hex digits", len(hexHash))
+		}
+	} else {
+		return entry, fmt.Errorf("%q is not a checksum line", line)
+	}
+
+	if _, has := manifestHashes[entry.algorithm]; !has {
+		return entry, fmt.Errorf("Unknown hash %q", entry.algorithm)
+	}
+	var err error
+	entry.hash, err = hex.DecodeString(hexHash)
+	if err != nil {
+		return entry, err
+	}
+	if escaped {
+		entry.path = strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(entry.path)
+	}
+	return entry, nil
+}
+
+// Verify the files in the tree at root against a checksum manifest.
+// The manifest takes the place of tree1, so a file which is listed in
+// it, but isn't in the tree, is reported as missing; a regular file in
+// the tree which isn't listed is reported as extra; and a file whose
+// hash differs from the manifest's is reported as a mismatch.
+// IgnoreFiles and ExcludeDirs apply to the search for extra files.
+func (s *ComparisonEngine) VerifyManifest(manifest io.Reader, root string,
+	options *DifftreeOptions) error {
+
+	s.Reset()
+	if options.OutputFormat == OutputItemize {
+		return errors.New("OutputItemize cannot be used to verify a manifest")
+	}
+	defer s.closeResultsDB()
+	if err := s.startReport(options); err != nil {
+		return err
+	}
+	if s.htmlReport != nil {
+		s.htmlReport.title = fmt.Sprintf("%s vs its manifest", root)
+	}
+	// Only files are verified
+	s.skipDirectoryComparison = true
+
+	manifestEntries, err := readManifest(manifest)
+	if err != nil {
+		return err
+	}
+
+	root = filepath.Clean(root)
+	listed := make(map[string]bool)
+	for _, manifestEntry := range manifestEntries {
+		relPath := filepath.Clean(filepath.FromSlash(manifestEntry.path))
+		listed[relPath] = true
+
+		entry := treeEntry{
+			path1:   filepath.Join(root, relPath),
+			path2:   filepath.Join(root, relPath),
+			relPath: relPath,
+		}
+		if filepath.IsAbs(relPath) || relPath == ".." ||
+			strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			entry.result = kError
+			entry.err = fmt.Errorf("%s is not a path inside the tree",
+				manifestEntry.path)
+		} else {
+			entry.verifyHash(manifestEntry, options)
+		}
+		s.handleResult(&entry, options)
+	}
+
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			entry := treeEntry{path1: path, path2: path, result: kError,
+				err: fmt.Errorf("While walking onto %s: %w", path, err)}
+			if len(path) > len(root) {
+				entry.relPath = path[len(root)+1:]
+			}
+			s.handleResult(&entry, options)
+			return nil
+		}
+		if path == root {
+			return nil
+		}
+		relPath := path[len(root)+1:]
+
+		if _, has := options.IgnoreFiles[info.Name()]; has {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if options.excludesDir(relPath) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() && !listed[relPath] {
+			entry := treeEntry{path1: path, path2: path, relPath: relPath,
+				result: kExtra}
+			s.handleResult(&entry, options)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.finishReport(options)
+}
+
+func (self *treeEntry) verifyHash(manifestEntry manifestEntry, options *DifftreeOptions) {
+	err := options.retry(func() error {
+		var err error
+		self.info2, err = os.Lstat(self.path2)
+		return err
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			self.result = kMissing
+			return
+		}
+		self.result = kError
+		self.err = err
+		return
+	}
+	self.hasInfo2 = true
+
+	if !self.info2.Mode().IsRegular() {
+		self.result = kDifferentTypes
+		self.description = fmt.Sprintf(
+			"the manifest has a regular file, but the tree has a %s",
+			translateModeType(self.info2.Mode()&os.ModeType))
+		return
+	}
+
+	var fileHash []byte
+	err = options.retry(func() error {
+		var err error
+		fileHash, err = hashFile(self.path2, manifestHashes[manifestEntry.algorithm]())
+		return err
+	})
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return
+	}
+	self.hash1 = manifestEntry.hash
+	self.hash2 = fileHash
+
+	if bytes.Equal(manifestEntry.hash, fileHash) {
+		self.result = kPerfectMatch
+		return
+	}
+	self.result = kMismatch
+	self.description = fmt.Sprintf("the manifest has %s %s, the file has %s %s",
+		manifestEntry.algorithm, hex.EncodeToString(manifestEntry.hash),
+		manifestEntry.algorithm, hex.EncodeToString(fileHash))
+}
@@ -0,0 +1,93 @@
+package difftreelib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The size and mtimes of a regular file which matched in both trees,
+// as written to WriteMatched
+type MatchedFile struct {
+	Size   int64
+	Mtime1 int64
+	Mtime2 int64
+}
+
+// Matched files by their paths, relative to the roots, as in a
+// baseline. Each line of the file is the path, the size, and the
+// mtimes in tree1 and tree2, in nanoseconds since the epoch, separated
+// by tabs:
+//
+//	etc/motd	286	1700000000000000000	1700000000500000000
+//
+// Blank lines, and lines starting with "#", are skipped.
+type MatchedFiles map[string]MatchedFile
+
+func ReadMatchedFiles(reader io.Reader) (MatchedFiles, error) {
+	matched := make(MatchedFiles)
+	scanner := bufio.NewScanner(reader)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("Line %d: %q is not PATH, SIZE, MTIME1 and MTIME2", lineNum, line)
+		}
+		var numbers [3]int64
+		for i, field := range fields[1:] {
+			number, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Line %d: %v", lineNum, err)
+			}
+			numbers[i] = number
+		}
+		matched[fields[0]] = MatchedFile{Size: numbers[0], Mtime1: numbers[1], Mtime2: numbers[2]}
+	}
+	return matched, scanner.Err()
+}
+
+func newMatchedFile(info1 os.FileInfo, info2 os.FileInfo) MatchedFile {
+	return MatchedFile{
+		Size:   info1.Size(),
+		Mtime1: info1.ModTime().UnixNano(),
+		Mtime2: info2.ModTime().UnixNano(),
+	}
+}
+
+// With SkipMatched, whether the two files matched before, and neither
+// has changed size or mtime since
+func (self *treeEntry) matchedBefore(options *DifftreeOptions) bool {
+	before, has := options.SkipMatched[baselinePath(self)]
+	return has && before == newMatchedFile(self.info1, self.info2) &&
+		self.info2.Size() == before.Size
+}
+
+// Keep a regular file which matched, for WriteMatched, unless it
+// was taken to match without being read
+func (s *ComparisonEngine) keepMatched(entry *treeEntry) {
+	if entry.result != kPerfectMatch || entry.hashAssumed || entry.info1 == nil ||
+		!entry.hasInfo2 || !entry.info1.Mode().IsRegular() || !entry.info2.Mode().IsRegular() {
+		return
+	}
+	matched := newMatchedFile(entry.info1, entry.info2)
+	s.matchedLines = append(s.matchedLines, fmt.Sprintf("%s\t%d\t%d\t%d\n",
+		baselinePath(entry), matched.Size, matched.Mtime1, matched.Mtime2))
+}
+
+// Write the files kept for WriteMatched, sorted by path
+func (s *ComparisonEngine) writeMatched(writer io.Writer) error {
+	sort.Strings(s.matchedLines)
+	for _, line := range s.matchedLines {
+		if _, err := io.WriteString(writer, line); err != nil {
+			return fmt.Errorf("Writing the matched files: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package difftreelib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteMatched(t *testing.T) {
+	path1, path2, cleanup := makeTrees(t,
+		testTree{"same": "abc", "changed": "abc", "sized": "abc"},
+		testTree{"same": "abc", "changed": "xyz", "sized": "abcd"})
+	defer cleanup()
+
+	var written bytes.Buffer
+	options := DifftreeOptions{WriteMatched: &written, Quiet: true}
+	var engine ComparisonEngine
+	if err := engine.Compare(path1, path2, &options); err == nil {
+		t.Error("WriteMatched without CheckHashes was allowed")
+	}
+
+	options.CheckHashes = true
+	compareQuietly(t, path1, path2, &options)
+	var paths []string
+	for _, line := range strings.Split(strings.TrimSpace(written.String()), "\n") {
+		paths = append(paths, strings.Split(line, "\t")[0])
+	}
+	if got := strings.Join(paths, " "); got != "same" {
+		t.Errorf("Wrote %q, want only \"same\"", got)
+	}
+}
+
+// A file taken to match under HashSampleRate, without being read, isn't
+// kept as a match
+func TestKeepMatchedSkipsAssumedHashes(t *testing.T) {
+	info := fakeFileInfo{name: "file", size: 3}
+	for _, assumed := range []bool{false, true} {
+		var engine ComparisonEngine
+		engine.keepMatched(&treeEntry{relPath: "file", result: kPerfectMatch,
+			info1: info, info2: info, hasInfo2: true, hashAssumed: assumed})
+		if kept := len(engine.matchedLines) == 1; kept == assumed {
+			t.Errorf("With hashAssumed %v, kept is %v", assumed, kept)
+		}
+	}
+}
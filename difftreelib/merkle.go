@@ -0,0 +1,162 @@
+package difftreelib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// For DirectoryMerkle, the hash of each directory in a tree, keyed by
+// its path relative to the root, with "" for the root itself.
+type dirHashes map[string][]byte
+
+// Compute a Merkle hash for each directory in the tree, bottom-up.
+// A directory's hash covers each entry's name, type, permissions,
+// size, and content hash (or symlink target, or directory hash), like
+// TreeHash, so two directories with the same hash have the same
+// subtrees. Entries which are skipped by the walk are skipped here too.
+func computeDirHashes(root string, options *DifftreeOptions) (dirHashes, error) {
+	root = filepath.Clean(root)
+
+	// The records of the entries in each directory, and the
+	// directories, whose records need their hashes
+	records := make(map[string][]string)
+	dirInfos := make(map[string]os.FileInfo)
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		var relPath string
+		if path != root {
+			relPath = path[len(root)+1:]
+		}
+		if relPath != "" {
+			if _, has := options.IgnoreFiles[info.Name()]; has ||
+				(options.IgnoreDotfiles && isDotfile(info.Name())) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		if info.IsDir() {
+			if options.excludesDir(relPath) {
+				return filepath.SkipDir
+			}
+			dirInfos[relPath] = info
+			return nil
+		}
+
+		var content []byte
+		if info.Mode().IsRegular() {
+			content, err = getFileHash(path, options)
+			if err != nil {
+				return err
+			}
+		} else if isLink(info) {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			content = []byte(target)
+		}
+		parent := parentRelPath(relPath)
+		records[parent] = append(records[parent], merkleRecord(info, content))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Deepest first, so each directory's subdirectories are hashed
+	// before it is
+	dirs := make([]string, 0, len(dirInfos))
+	for relPath := range dirInfos {
+		dirs = append(dirs, relPath)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return pathDepth(dirs[i]) > pathDepth(dirs[j])
+	})
+
+	hashes := make(dirHashes, len(dirs))
+	for _, relPath := range dirs {
+		dirRecords := records[relPath]
+		sort.Strings(dirRecords)
+		hasher := newHasher(options)
+		for _, record := range dirRecords {
+			hasher.Write([]byte(record))
+		}
+		hashes[relPath] = hasher.Sum(nil)
+
+		if relPath != "" {
+			parent := parentRelPath(relPath)
+			records[parent] = append(records[parent],
+				merkleRecord(dirInfos[relPath], hashes[relPath]))
+		}
+	}
+	return hashes, nil
+}
+
+func merkleRecord(info os.FileInfo, content []byte) string {
+	fileType := info.Mode() & os.ModeType
+	var size int64
+	if fileType == 0 {
+		size = info.Size()
+	}
+	return fmt.Sprintf("%s\x00%s\x00%o\x00%d\x00%x\n", info.Name(),
+		translateModeType(fileType), info.Mode().Perm(), size, content)
+}
+
+// The relative path of the directory containing relPath; "" is the root
+func parentRelPath(relPath string) string {
+	parent := filepath.Dir(relPath)
+	if parent == "." {
+		return ""
+	}
+	return parent
+}
+
+func pathDepth(relPath string) int {
+	if relPath == "" {
+		return 0
+	}
+	return strings.Count(relPath, string(filepath.Separator)) + 1
+}
+
+// Hash both trees for DirectoryMerkle. If either can't be hashed,
+// there is no pruning, and every path is compared as usual.
+func (s *ComparisonEngine) startDirHashes(path1 string, path2 string,
+	options *DifftreeOptions) {
+
+	hashes1, err := computeDirHashes(path1, options)
+	if err != nil {
+		logError("Cannot hash the directories of %s, so none are pruned: %v", path1, err)
+		return
+	}
+	hashes2, err := computeDirHashes(path2, options)
+	if err != nil {
+		logError("Cannot hash the directories of %s, so none are pruned: %v", path2, err)
+		return
+	}
+	s.dirHashes1 = hashes1
+	s.dirHashes2 = hashes2
+}
+
+// Whether the directory's subtree is the same in both trees, by
+// their Merkle hashes
+func (s *ComparisonEngine) sameSubtree(entry *treeEntry, options *DifftreeOptions) bool {
+	relPath2 := entry.relPath
+	if options.PathMap != nil && relPath2 != "" {
+		relPath2 = filepath.Clean(options.PathMap(relPath2))
+		if relPath2 == "." {
+			relPath2 = ""
+		}
+	}
+	hash1, ok1 := s.dirHashes1[entry.relPath]
+	hash2, ok2 := s.dirHashes2[relPath2]
+	return ok1 && ok2 && cmpByteSlices(hash1, hash2)
+}
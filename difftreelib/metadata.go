@@ -0,0 +1,193 @@
+package difftreelib
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A difference in metadata found between two paths of the same type
+type metadataDiff struct {
+	result      resultType
+	description string
+}
+
+// Compare the metadata of the two paths. Unless all is true, this
+// stops at the first difference.
+func (self *treeEntry) compareMetadata(options *DifftreeOptions, all bool) []metadataDiff {
+	var diffs []metadataDiff
+
+	// Same permissions? For directories, the special bits matter too.
+	// The permissions of symlinks aren't used, and on some systems,
+	// they vary, so they aren't compared. (The types were compared
+	// already, so both paths are symlinks, or neither is.)
+	mask := options.permMask()
+	permsDiffer := !isLink(self.info1) &&
+		self.info1.Mode().Perm()&mask != self.info2.Mode().Perm()&mask
+	var specialDiffs []string
+	if self.info1.IsDir() {
+		specialDiffs = describeSpecialBits(self.info1.Mode(), self.info2.Mode())
+	}
+	if (permsDiffer || len(specialDiffs) > 0) &&
+		options.permChangeAllowed(self.info1.Mode(), self.info2.Mode()) {
+		// Reported only if nothing else differs
+		self.acceptedPerms = fmt.Sprintf("perms changed from %s to %s, which is allowed",
+			options.formatPerms(self.info1.Mode()), options.formatPerms(self.info2.Mode()))
+	} else if permsDiffer || len(specialDiffs) > 0 {
+		perms1 := options.formatPerms(self.info1.Mode())
+		perms2 := options.formatPerms(self.info2.Mode())
+		description := fmt.Sprintf("file1 has perms %s, but file2 has %s",
+			perms1, perms2)
+		if len(specialDiffs) > 0 {
+			description = fmt.Sprintf("%s (dir1 has perms %s, dir2 has %s)",
+				strings.Join(specialDiffs, ", "), perms1, perms2)
+		}
+		if options.ShowFullModeDiff {
+			description = self.describeFullModes()
+		}
+		diffs = append(diffs, metadataDiff{
+			result:      kDifferentPermissions,
+			description: description,
+		})
+		if !all {
+			return diffs
+		}
+	}
+
+	// Same owners?
+	if options.CheckOwnership {
+		if diff, differ := self.compareOwners(options); differ {
+			diffs = append(diffs, diff)
+			if !all {
+				return diffs
+			}
+		}
+	}
+
+	// Same extended attributes?
+	if options.CheckXattrs {
+		if diff, differ := self.compareXattrs(options); differ {
+			diffs = append(diffs, diff)
+			if !all {
+				return diffs
+			}
+		}
+	}
+
+	// Same directory modification times?
+	if options.CheckDirMtime && self.info1.IsDir() {
+		if diff, differ := self.compareDirMtimes(options); differ {
+			diffs = append(diffs, diff)
+			if !all {
+				return diffs
+			}
+		}
+	}
+
+	// Same creation times?
+	if options.CheckBirthtime {
+		if diff, differ := self.compareBirthtimes(options); differ {
+			diffs = append(diffs, diff)
+			if !all {
+				return diffs
+			}
+		}
+	}
+
+	// Same project IDs?
+	if options.CheckProjectID {
+		if diff, differ := self.compareProjectIDs(options); differ {
+			diffs = append(diffs, diff)
+			if !all {
+				return diffs
+			}
+		}
+	}
+
+	// Same inode generations?
+	if options.CheckInodeGeneration {
+		if diff, differ := self.compareInodeGenerations(options); differ {
+			diffs = append(diffs, diff)
+			if !all {
+				return diffs
+			}
+		}
+	}
+
+	return diffs
+}
+
+// With CombineMetadataDiffs, the metadata differences are folded into
+// the result of comparing the contents. If the contents matched, the
+// result becomes kMetadataDiffers.
+func (self *treeEntry) combineMetadataDiffs(diffs []metadataDiff) {
+	lines := make([]string, len(diffs))
+	for i, diff := range diffs {
+		lines[i] = "    " + diff.description
+	}
+	list := strings.Join(lines, "\n")
+
+	switch self.result {
+	case kPerfectMatch, kDirSameEntries, kDirNotCompared:
+		self.result = kMetadataDiffers
+		self.description = "contents match, but metadata differs:\n" + list
+	case kError:
+	default:
+		self.description += "\nmetadata also differs:\n" + list
+	}
+}
+
+// Describe the full modes of both paths, and what differs between them,
+// in a single line, as in:
+//
+//	file1 has mode -rw-r--r--, file2 has mode Lrwxrwxrwx (type and perms differ)
+func (self *treeEntry) describeFullModes() string {
+	mode1 := self.info1.Mode()
+	mode2 := self.info2.Mode()
+
+	var differ []string
+	if mode1&os.ModeType != mode2&os.ModeType {
+		differ = append(differ, "type")
+	}
+	if mode1&^os.ModeType != mode2&^os.ModeType {
+		differ = append(differ, "perms")
+	}
+	return fmt.Sprintf("file1 has mode %s, file2 has mode %s (%s differ)",
+		mode1.String(), mode2.String(), strings.Join(differ, " and "))
+}
+
+var specialBitNames = []struct {
+	bit   os.FileMode
+	name  string
+	octal uint32
+}{
+	{os.ModeSetuid, "setuid", 04000},
+	{os.ModeSetgid, "setgid", 02000},
+	{os.ModeSticky, "sticky bit", 01000},
+}
+
+// Render the permissions of a mode as PermFormat chooses: symbolic,
+// as in "-rwxr-xr-x", or octal, as in "0755", with the special bits
+// as chmod numbers them.
+func (self *DifftreeOptions) formatPerms(mode os.FileMode) string {
+	if self.PermFormat != PermFormatOctal {
+		return mode.String()
+	}
+	return fmt.Sprintf("%04o", unixPerms(mode))
+}
+
+// Describe how the setuid, setgid, and sticky bits changed from mode1
+// to mode2, as in "sticky bit added".
+func describeSpecialBits(mode1 os.FileMode, mode2 os.FileMode) []string {
+	var diffs []string
+	for _, special := range specialBitNames {
+		has1 := mode1&special.bit != 0
+		has2 := mode2&special.bit != 0
+		if has1 && !has2 {
+			diffs = append(diffs, special.name+" removed")
+		} else if !has1 && has2 {
+			diffs = append(diffs, special.name+" added")
+		}
+	}
+	return diffs
+}
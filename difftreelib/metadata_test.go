@@ -0,0 +1,157 @@
+package difftreelib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSymlinkPermsNotCompared(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode1     os.FileMode
+		mode2     os.FileMode
+		wantDiffs int
+	}{
+		{"symlinks", os.ModeSymlink | 0777, os.ModeSymlink | 0755, 0},
+		{"symlinks with special bits", os.ModeSymlink | 0777,
+			os.ModeSymlink | os.ModeSticky | 0700, 0},
+		{"regular files", 0644, 0600, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry := treeEntry{
+				info1: fakeFileInfo{name: "link", mode: test.mode1},
+				info2: fakeFileInfo{name: "link", mode: test.mode2},
+			}
+			diffs := entry.compareMetadata(&DifftreeOptions{}, true)
+			if len(diffs) != test.wantDiffs {
+				t.Errorf("compareMetadata() = %+v, want %d differences", diffs,
+					test.wantDiffs)
+			}
+		})
+	}
+}
+
+func TestPermFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		mode1  os.FileMode
+		mode2  os.FileMode
+		want   string
+	}{
+		{"", 0755, 0644, "file1 has perms -rwxr-xr-x, but file2 has -rw-r--r--"},
+		{PermFormatSymbolic, 0755, 0644, "file1 has perms -rwxr-xr-x, but file2 has -rw-r--r--"},
+		{PermFormatOctal, 0755, 0644, "file1 has perms 0755, but file2 has 0644"},
+		{PermFormatOctal, os.ModeDir | os.ModeSticky | 0777, os.ModeDir | 0777,
+			"sticky bit removed (dir1 has perms 1777, dir2 has 0777)"},
+	}
+	for _, test := range tests {
+		entry := treeEntry{
+			info1: fakeFileInfo{name: "file", mode: test.mode1},
+			info2: fakeFileInfo{name: "file", mode: test.mode2},
+		}
+		diffs := entry.compareMetadata(&DifftreeOptions{PermFormat: test.format}, false)
+		if len(diffs) != 1 {
+			t.Errorf("PermFormat %q: compareMetadata() = %+v, want 1 difference",
+				test.format, diffs)
+			continue
+		}
+		if diffs[0].description != test.want {
+			t.Errorf("PermFormat %q: %q, want %q", test.format, diffs[0].description,
+				test.want)
+		}
+	}
+}
+
+func TestDescribeFullModes(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode1 os.FileMode
+		mode2 os.FileMode
+		want  string
+	}{
+		{"type", 0777, os.ModeSymlink | 0777,
+			"file1 has mode -rwxrwxrwx, file2 has mode Lrwxrwxrwx (type differ)"},
+		{"perms", 0644, 0755,
+			"file1 has mode -rw-r--r--, file2 has mode -rwxr-xr-x (perms differ)"},
+		{"special bits", os.ModeDir | os.ModeSticky | 0777, os.ModeDir | 0777,
+			"file1 has mode dtrwxrwxrwx, file2 has mode drwxrwxrwx (perms differ)"},
+		{"type and perms", 0644, os.ModeSymlink | 0777,
+			"file1 has mode -rw-r--r--, file2 has mode Lrwxrwxrwx (type and perms differ)"},
+		{"directory and file", os.ModeDir | 0700, 0644,
+			"file1 has mode drwx------, file2 has mode -rw-r--r-- (type and perms differ)"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry := treeEntry{
+				info1: fakeFileInfo{name: "file", mode: test.mode1},
+				info2: fakeFileInfo{name: "file", mode: test.mode2},
+			}
+			if got := entry.describeFullModes(); got != test.want {
+				t.Errorf("describeFullModes() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestShowFullModeDiff(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows has no Unix permissions")
+	}
+	path1, path2, cleanup := makeTrees(t,
+		testTree{"type": "x", "perms": "x", "both": "x", "dir/": ""},
+		testTree{"type": symlinkTo + "perms", "perms": "x", "both": symlinkTo + "perms",
+			"dir": "x"})
+	defer cleanup()
+	chmods := []struct {
+		path string
+		mode os.FileMode
+	}{
+		{filepath.Join(path1, "type"), 0777},
+		{filepath.Join(path1, "perms"), 0644},
+		{filepath.Join(path2, "perms"), 0755},
+		{filepath.Join(path1, "both"), 0644},
+		{filepath.Join(path1, "dir"), 0700},
+		{filepath.Join(path2, "dir"), 0644},
+	}
+	for _, chmod := range chmods {
+		if err := os.Chmod(chmod.path, chmod.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var events bytes.Buffer
+	options := DifftreeOptions{Events: &events, ShowFullModeDiff: true}
+	compareQuietly(t, path1, path2, &options)
+	descriptions := make(map[string]string)
+	scanner := bufio.NewScanner(&events)
+	for scanner.Scan() {
+		var event resultEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Type == "result" {
+			descriptions[event.Path] = event.Description
+		}
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"type", "file1 has mode -rwxrwxrwx, file2 has mode Lrwxrwxrwx (type differ)"},
+		{"perms", "file1 has mode -rw-r--r--, file2 has mode -rwxr-xr-x (perms differ)"},
+		{"both", "file1 has mode -rw-r--r--, file2 has mode Lrwxrwxrwx (type and perms differ)"},
+		{"dir", "file1 has mode drwx------, file2 has mode -rw-r--r-- (type and perms differ)"},
+	}
+	for _, test := range tests {
+		if got := descriptions[test.path]; got != test.want {
+			t.Errorf("%s: %q, want %q", test.path, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package difftreelib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Write the stats as metrics in the Prometheus text exposition
+// format, as read by node_exporter's textfile collector. Each count is
+// named for its JSON key, like difftree_mismatches_total, and is a
+// gauge, as it is replaced by each comparison, not added to. Every
+// metric is labeled with the two roots, and the time of the comparison
+// is difftree_last_run_timestamp_seconds, as the textfile collector
+// doesn't accept timestamps on samples.
+func (self Stats) WriteMetrics(writer io.Writer, path1 string, path2 string,
+	when time.Time) error {
+
+	labels := fmt.Sprintf(`{path1="%s",path2="%s"}`, escapeLabelValue(path1),
+		escapeLabelValue(path2))
+	out := bufio.NewWriter(writer)
+	metric := func(name string, help string, value interface{}) {
+		fmt.Fprintf(out, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(out, "# TYPE %s gauge\n", name)
+		fmt.Fprintf(out, "%s%s %v\n", name, labels, value)
+	}
+
+	statsValue := reflect.ValueOf(self)
+	statsType := statsValue.Type()
+	for i := 0; i < statsType.NumField(); i++ {
+		field := statsType.Field(i)
+		key := strings.Split(field.Tag.Get("json"), ",")[0]
+		value := statsValue.Field(i)
+		switch value.Kind() {
+		case reflect.Int:
+			metric("difftree_"+key+"_total",
+				fmt.Sprintf("The count of Stats.%s", field.Name), value.Int())
+		case reflect.Float64:
+			metric("difftree_"+key, fmt.Sprintf("Stats.%s", field.Name), value.Float())
+		case reflect.Bool:
+			var flag int
+			if value.Bool() {
+				flag = 1
+			}
+			metric("difftree_"+key, fmt.Sprintf("Whether Stats.%s is set", field.Name), flag)
+		}
+	}
+	metric("difftree_last_run_timestamp_seconds",
+		"When the comparison finished, in seconds since the epoch", when.Unix())
+	return out.Flush()
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,58 @@
+package difftreelib
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Names are read, kept, and compared as raw bytes, so two names which
+// aren't valid UTF-8 match only if their bytes do. They are mangled
+// only where they are written: encoding/json replaces each invalid
+// byte with U+FFFD, so different names could look the same.
+//
+// So text which isn't valid UTF-8 is escaped: each byte which isn't
+// part of a valid UTF-8 sequence is written as \xHH, with two
+// lowercase hex digits, and each backslash is doubled. Text which is
+// valid UTF-8 is written unchanged, so the escaping can only be undone
+// when the writer says it was applied, as the "escaped" key of the
+// events does.
+func escapeInvalidUTF8(text string) string {
+	if utf8.ValidString(text) {
+		return text
+	}
+	return escapeUTF8(text)
+}
+
+// Escape the text whether or not it is valid UTF-8, as
+// escapeInvalidUTF8 describes
+func escapeUTF8(text string) string {
+	var builder strings.Builder
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&builder, `\x%02x`, text[i])
+		case r == '\\':
+			builder.WriteString(`\\`)
+		default:
+			builder.WriteString(text[i : i+size])
+		}
+		i += size
+	}
+	return builder.String()
+}
+
+// The path and description of the entry as they are printed, escaped
+// for DifftreeOptions.EscapeInvalidNames
+func (s *ComparisonEngine) displayText(entry *treeEntry,
+	options *DifftreeOptions) (string, string) {
+
+	relativePath := s.relativePath(entry)
+	description := entry.description
+	if options.EscapeInvalidNames {
+		relativePath = escapeInvalidUTF8(relativePath)
+		description = escapeInvalidUTF8(description)
+	}
+	return relativePath, description
+}
@@ -0,0 +1,91 @@
+package difftreelib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestEscapeInvalidUTF8(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{"café", "café"},
+		// Valid text is unchanged, backslashes and all
+		{`back\slash`, `back\slash`},
+		{"bad\xff", `bad\xff`},
+		{"bad\xfe", `bad\xfe`},
+		{"\xc3", `\xc3`},
+		{"café\xff", `caf` + "é" + `\xff`},
+		{"back\\slash\xff", `back\\slash\xff`},
+		{`\xff` + "\xff", `\\xff\xff`},
+	}
+	for _, test := range tests {
+		if got := escapeInvalidUTF8(test.text); got != test.want {
+			t.Errorf("escapeInvalidUTF8(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestInvalidUTF8Names(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	if err := (testTree{"probe\xff": ""}).makeOrError(dir); err != nil {
+		t.Skipf("Names which aren't UTF-8 can't be made here: %v", err)
+	}
+
+	path1, path2, cleanup := makeTrees(t,
+		testTree{"same\xff": "1", "mismatch\xfe": "1", "name\xfd": "1"},
+		testTree{"same\xff": "1", "mismatch\xfe": "22", "name\xfc": "1"})
+	defer cleanup()
+
+	var events bytes.Buffer
+	options := DifftreeOptions{Events: &events}
+	stats := compareQuietly(t, path1, path2, &options)
+	if stats.PerfectMatch != 1 || stats.Mismatch != 1 || stats.Missing != 1 ||
+		stats.DirDifferent != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// Names which would both be "name�" as JSON strings are
+	// distinct, and escaped
+	results := make(map[string]resultEvent)
+	scanner := bufio.NewScanner(&events)
+	for scanner.Scan() {
+		var event resultEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Type == "result" {
+			results[event.Path] = event
+		}
+	}
+	tests := []struct {
+		path   string
+		result resultType
+	}{
+		{`same\xff`, kPerfectMatch},
+		{`mismatch\xfe`, kMismatch},
+		{`name\xfd`, kMissing},
+	}
+	for _, test := range tests {
+		event, ok := results[test.path]
+		if !ok {
+			t.Errorf("no result for %s", test.path)
+			continue
+		}
+		if event.Result != test.result.String() || !event.Escaped {
+			t.Errorf("%s: %s, escaped %v, want %s, escaped", test.path, event.Result,
+				event.Escaped, test.result)
+		}
+	}
+	root := results[path1]
+	if !strings.Contains(root.Description, `name\xfc`) {
+		t.Errorf("%s: %q doesn't list name\\xfc", path1, root.Description)
+	}
+}
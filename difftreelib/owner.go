@@ -0,0 +1,52 @@
+package difftreelib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A range of uids or gids, from First to Last, inclusive
+type IDRange struct {
+	First uint32
+	Last  uint32
+}
+
+func inRanges(id uint32, ranges []IDRange) bool {
+	for _, r := range ranges {
+		if id >= r.First && id <= r.Last {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the owners differ. A change between two ids which
+// are both in the ignored ranges doesn't count. If either owner is not
+// available, they are not compared.
+func (self *treeEntry) compareOwners(options *DifftreeOptions) (metadataDiff, bool) {
+	uid1, gid1, ok1 := fileOwner(self.info1)
+	uid2, gid2, ok2 := fileOwner(self.info2)
+	if !ok1 || !ok2 {
+		return metadataDiff{}, false
+	}
+
+	var diffs []string
+	if uid1 != uid2 &&
+		!(inRanges(uid1, options.IgnoreUIDs) && inRanges(uid2, options.IgnoreUIDs)) {
+		diffs = append(diffs, fmt.Sprintf("file1 has uid %d, file2 has uid %d",
+			uid1, uid2))
+	}
+	if gid1 != gid2 &&
+		!(inRanges(gid1, options.IgnoreGIDs) && inRanges(gid2, options.IgnoreGIDs)) {
+		diffs = append(diffs, fmt.Sprintf("file1 has gid %d, file2 has gid %d",
+			gid1, gid2))
+	}
+	if len(diffs) == 0 {
+		return metadataDiff{}, false
+	}
+
+	return metadataDiff{
+		result:      kDifferentOwner,
+		description: strings.Join(diffs, "; "),
+	}, true
+}
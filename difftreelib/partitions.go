@@ -0,0 +1,58 @@
+package difftreelib
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The top-level directories of tree1, each of which is walked by its
+// own reader for PartitionTopLevel. If tree1 can't be read, there are
+// none, and the single walk of the root reports the error.
+func topLevelDirs(path1 string) []string {
+	infos, err := ioutil.ReadDir(path1)
+	if err != nil {
+		logError("Cannot partition %s: %v", path1, err)
+		return nil
+	}
+	var dirs []string
+	for _, info := range infos {
+		if info.IsDir() {
+			dirs = append(dirs, filepath.Join(path1, info.Name()))
+		}
+	}
+	return dirs
+}
+
+// Walk the partitions of tree1 concurrently. The first reader walks
+// the root and the files directly in it, and each of the others walks
+// one top-level directory, into its own filledEntryChan.
+func (s *ComparisonEngine) readPartitions(path1 string, path2 string, partitions []string,
+	blankEntryChan chan *treeEntry, filledEntryChans []chan *treeEntry,
+	options *DifftreeOptions) {
+
+	// The channels are closed only after the timing is recorded, so
+	// that it is complete once all of the results are in
+	for _, filledEntryChan := range filledEntryChans {
+		defer close(filledEntryChan)
+	}
+	if options.Timing {
+		defer recordDuration(&s.timing.walk, time.Now())
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(filledEntryChans))
+	for i, filledEntryChan := range filledEntryChans {
+		walkRoot := path1
+		if i > 0 {
+			walkRoot = partitions[i-1]
+		}
+		go func(i int, walkRoot string, filledEntryChan chan *treeEntry) {
+			defer wg.Done()
+			s.walkTree(walkRoot, path1, path2, i == 0, int64(i),
+				blankEntryChan, filledEntryChan, options)
+		}(i, walkRoot, filledEntryChan)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,40 @@
+package difftreelib
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// A rule for PrefixPathMap: a relative path in tree1 which starts with
+// the From directory starts with the To directory in tree2 instead.
+// An empty From adds To to every path; an empty To strips From.
+type PrefixRule struct {
+	From string
+	To   string
+}
+
+// Make a PathMap which rewrites each relative path by the first rule
+// whose From matches it. From matches whole path components, so "v1"
+// matches "v1/lib", but not "v10/lib". Paths which no rule matches are
+// unchanged.
+func PrefixPathMap(rules []PrefixRule) func(string) string {
+	return func(relPath string) string {
+		for _, rule := range rules {
+			from := filepath.Clean(filepath.FromSlash(rule.From))
+			to := filepath.FromSlash(rule.To)
+			var rest string
+			switch {
+			case rule.From == "":
+				rest = relPath
+			case relPath == from:
+				rest = ""
+			case strings.HasPrefix(relPath, from+string(filepath.Separator)):
+				rest = relPath[len(from)+1:]
+			default:
+				continue
+			}
+			return filepath.Join(to, rest)
+		}
+		return relPath
+	}
+}
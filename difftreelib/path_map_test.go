@@ -0,0 +1,38 @@
+package difftreelib
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPrefixPathMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []PrefixRule
+		relPath string
+		want    string
+	}{
+		{"add", []PrefixRule{{"", "v1"}}, "lib/a.go", "v1/lib/a.go"},
+		{"strip", []PrefixRule{{"v1", ""}}, "v1/lib/a.go", "lib/a.go"},
+		{"replace", []PrefixRule{{"v1/lib", "v2"}}, "v1/lib/a.go", "v2/a.go"},
+		{"exact match", []PrefixRule{{"v1", "v2"}}, "v1", "v2"},
+		{"component boundary", []PrefixRule{{"v1", "v2"}}, "v10/lib", "v10/lib"},
+		{"trailing slash", []PrefixRule{{"v1/", "v2"}}, "v1/lib", "v2/lib"},
+		{"first rule wins", []PrefixRule{{"v1", "first"}, {"v1/lib", "second"}},
+			"v1/lib/a.go", "first/lib/a.go"},
+		{"later rule matches", []PrefixRule{{"v2", "first"}, {"v1", "second"}},
+			"v1/a.go", "second/a.go"},
+		{"no match", []PrefixRule{{"v1", "v2"}, {"v3", ""}}, "lib/a.go", "lib/a.go"},
+		{"no rules", nil, "lib/a.go", "lib/a.go"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pathMap := PrefixPathMap(test.rules)
+			got := pathMap(filepath.FromSlash(test.relPath))
+			if want := filepath.FromSlash(test.want); got != want {
+				t.Errorf("PrefixPathMap(%v)(%q) = %q, want %q", test.rules,
+					test.relPath, got, want)
+			}
+		})
+	}
+}
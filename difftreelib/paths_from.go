@@ -0,0 +1,108 @@
+package difftreelib
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Instead of walking tree1, read the relative paths to compare from
+// options.PathsFrom, one per line.
+func (s *ComparisonEngine) readListedEntries(path1 string, path2 string,
+	blankEntryChan chan *treeEntry, filledEntryChan chan *treeEntry, options *DifftreeOptions) {
+
+	defer close(filledEntryChan)
+	if options.Timing {
+		defer recordDuration(&s.timing.walk, time.Now())
+	}
+
+	var order int
+
+	var hashSampler *rand.Rand
+	if options.hashSampling() {
+		hashSampler = rand.New(rand.NewSource(options.HashSampleSeed))
+	}
+
+	scanner := bufio.NewScanner(options.PathsFrom)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if s.stopReading() != nil {
+			return
+		}
+
+		entry, ok := <-blankEntryChan
+		if !ok {
+			return
+		}
+		entry.order = order
+		order++
+		s.fillListedEntry(entry, line, path1, path2, options)
+		if hashSampler != nil {
+			entry.hashSampled = hashSampler.Float64() < options.HashSampleRate
+		}
+		filledEntryChan <- entry
+	}
+
+	if err := scanner.Err(); err != nil {
+		entry, ok := <-blankEntryChan
+		if !ok {
+			return
+		}
+		entry.order = order
+		entry.path1 = path1
+		entry.result = kError
+		entry.err = fmt.Errorf("Reading the list of paths: %v", err)
+		filledEntryChan <- entry
+	}
+}
+
+func (s *ComparisonEngine) fillListedEntry(entry *treeEntry, line string,
+	path1 string, path2 string, options *DifftreeOptions) {
+
+	relPath := filepath.Clean(filepath.FromSlash(line))
+	if filepath.IsAbs(relPath) || relPath == ".." ||
+		strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		entry.path1 = path1
+		entry.relPath = line
+		entry.result = kError
+		entry.err = fmt.Errorf("%s is not a path inside the tree", line)
+		return
+	}
+
+	if relPath == "." {
+		relPath = ""
+	}
+	entry.relPath = relPath
+	entry.path1 = filepath.Join(path1, relPath)
+
+	if _, has := options.IgnoreFiles[filepath.Base(relPath)]; has && relPath != "" {
+		entry.result = kIgnored
+		return
+	}
+
+	info, err := os.Lstat(entry.path1)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			entry.result = kError
+			entry.err = err
+			return
+		}
+		// Not in tree1; is it in tree2?
+		entry.computePath2(s.path1RootLen, path2, options.PathMap)
+		if _, err2 := os.Lstat(entry.path2); err2 == nil {
+			entry.result = kExtra
+		} else {
+			entry.result = kError
+			entry.err = fmt.Errorf("%s is in neither tree", relPath)
+		}
+		return
+	}
+	entry.info1 = info
+}
@@ -0,0 +1,78 @@
+package difftreelib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A change of permissions which is expected, from From in tree1 to To
+// in tree2, as chmod numbers them, like 0644 to 0664
+type PermTransition struct {
+	From uint32
+	To   uint32
+}
+
+// Parse a transition written as "FROM:TO", in octal, like "0644:0664"
+func ParsePermTransition(text string) (PermTransition, error) {
+	i := strings.IndexByte(text, ':')
+	if i == -1 {
+		return PermTransition{}, fmt.Errorf("%q is not FROM:TO", text)
+	}
+	from, err := strconv.ParseUint(strings.TrimSpace(text[:i]), 8, 32)
+	if err != nil {
+		return PermTransition{}, err
+	}
+	to, err := strconv.ParseUint(strings.TrimSpace(text[i+1:]), 8, 32)
+	if err != nil {
+		return PermTransition{}, err
+	}
+	if from > 07777 || to > 07777 {
+		return PermTransition{}, fmt.Errorf("%q has bits other than permissions", text)
+	}
+	return PermTransition{From: uint32(from), To: uint32(to)}, nil
+}
+
+// Read transitions, one "FROM:TO" per line. Blank lines, and lines
+// starting with "#", are skipped.
+func ReadPermTransitions(reader io.Reader) ([]PermTransition, error) {
+	var transitions []PermTransition
+	scanner := bufio.NewScanner(reader)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		transition, err := ParsePermTransition(line)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %v", lineNum, err)
+		}
+		transitions = append(transitions, transition)
+	}
+	return transitions, scanner.Err()
+}
+
+// The permissions of a mode as chmod numbers them, with the special bits
+func unixPerms(mode os.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	for _, special := range specialBitNames {
+		if mode&special.bit != 0 {
+			bits |= special.octal
+		}
+	}
+	return bits
+}
+
+func (self *DifftreeOptions) permChangeAllowed(mode1 os.FileMode, mode2 os.FileMode) bool {
+	from := unixPerms(mode1)
+	to := unixPerms(mode2)
+	for _, transition := range self.AllowedPermChanges {
+		if transition.From == from && transition.To == to {
+			return true
+		}
+	}
+	return false
+}
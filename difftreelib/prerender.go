@@ -0,0 +1,44 @@
+package difftreelib
+
+import (
+	"bytes"
+)
+
+// For PrerenderOutput, render the entry's text report and event in the
+// comparison worker, so that reportResults, which handles every result
+// in turn, only has to write them. They are kept with the result and
+// description they were rendered from, and handleResult renders the
+// entry again if either has changed since, as with Baseline.
+func (s *ComparisonEngine) prerender(entry *treeEntry, options *DifftreeOptions) {
+	if options.Events != nil {
+		data, err := renderResultEvent(entry.toResult(s.relativePath(entry)))
+		if err != nil {
+			logError("Cannot render the event of %s: %v", entry.path1, err)
+		} else {
+			entry.renderedEvent = data
+		}
+	}
+
+	textOutput := options.OutputFormat == "" || options.OutputFormat == OutputText
+	if textOutput && !options.Quiet && !options.TreeView {
+		var buffer bytes.Buffer
+		s.writeResult(&buffer, entry, options)
+		entry.renderedText = buffer.Bytes()
+	}
+
+	entry.renderedResult = entry.result
+	entry.renderedDescription = entry.description
+}
+
+func (self *treeEntry) renderedStillValid() bool {
+	return self.result == self.renderedResult &&
+		self.description == self.renderedDescription
+}
+
+func (self *treeEntry) renderedEventValid() bool {
+	return self.renderedEvent != nil && self.renderedStillValid()
+}
+
+func (self *treeEntry) renderedTextValid() bool {
+	return self.renderedText != nil && self.renderedStillValid()
+}
@@ -0,0 +1,74 @@
+package difftreelib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPrerenderOutput(t *testing.T) {
+	path1, path2, cleanup := makeTrees(t,
+		testTree{"same": "x", "changed": "1", "missing": "x", "dir/file": "1"},
+		testTree{"same": "x", "changed": "2", "extra": "x", "dir/file": "22"})
+	defer cleanup()
+
+	events := make(map[bool]string)
+	for _, prerender := range []bool{false, true} {
+		var buffer bytes.Buffer
+		options := DifftreeOptions{Events: &buffer, PrerenderOutput: prerender}
+		compareQuietly(t, path1, path2, &options)
+		// The workers may finish in any order
+		lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+		sort.Strings(lines)
+		events[prerender] = strings.Join(lines, "\n")
+	}
+	if events[false] == "" {
+		t.Fatal("No events were written")
+	}
+	if events[true] != events[false] {
+		t.Errorf("Prerendered events:\n%s\nwant:\n%s", events[true], events[false])
+	}
+}
+
+// Compare a tree of 20k files with an empty one, so that every file is
+// a result, with and without PrerenderOutput, writing the text report
+// to /dev/null and the events to ioutil.Discard
+func BenchmarkPrerenderOutput(b *testing.B) {
+	tree1 := make(testTree)
+	for i := 0; i < 20000; i++ {
+		tree1[fmt.Sprintf("dir%04d/file%06d", i/100, i)] = "x"
+	}
+	path1, path2, cleanup := makeTrees(b, tree1, testTree{})
+	defer cleanup()
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+	stdout := os.Stdout
+	os.Stdout = devNull
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	for _, prerender := range []bool{false, true} {
+		b.Run(fmt.Sprintf("prerender %v", prerender), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				options := DifftreeOptions{
+					Events:          ioutil.Discard,
+					PrerenderOutput: prerender,
+					Workers:         8,
+				}
+				var engine ComparisonEngine
+				if err := engine.Compare(path1, path2, &options); err != nil {
+					b.Fatalf("Compare: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package difftreelib
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The time spent comparing the paths in each directory, keyed by the
+// directory's stack of names from the root, separated by ";". A
+// directory's own comparison counts as time in the directory. The
+// profile is written in the folded-stack format, a line per stack
+// with its time in microseconds, such as:
+//
+//	.;usr;lib 1234
+//
+// so that flamegraph.pl, and the like, show which parts of the tree
+// are slow to compare.
+type pathProfile map[string]time.Duration
+
+func (self pathProfile) add(entry *treeEntry) {
+	dir := entry.relPath
+	if entry.info1 == nil || !entry.info1.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	stack := "."
+	if dir != "" && dir != "." {
+		stack += ";" + strings.Replace(dir, string(filepath.Separator), ";", -1)
+	}
+	self[stack] += entry.compareTime
+}
+
+func (self pathProfile) write(writer io.Writer) error {
+	stacks := make([]string, 0, len(self))
+	for stack := range self {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	for _, stack := range stacks {
+		_, err := fmt.Fprintf(writer, "%s %d\n", stack,
+			self[stack].Microseconds())
+		if err != nil {
+			return fmt.Errorf("Writing the profile: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package difftreelib
+
+import (
+	"fmt"
+)
+
+// Returns true if the project IDs, used for project quotas, differ.
+// If either can't be read, they are not compared.
+func (self *treeEntry) compareProjectIDs(options *DifftreeOptions) (metadataDiff, bool) {
+	// Reading a project ID means opening the path, which isn't done
+	// for symlinks, devices or pipes
+	if !self.info1.Mode().IsRegular() && !self.info1.IsDir() {
+		return metadataDiff{}, false
+	}
+
+	id1, ok1, err := getProjectID(self.path1)
+	if err != nil {
+		logError("Cannot read the project ID of %s: %v", self.path1, err)
+		return metadataDiff{}, false
+	}
+	id2, ok2, err := getProjectID(self.path2)
+	if err != nil {
+		logError("Cannot read the project ID of %s: %v", self.path2, err)
+		return metadataDiff{}, false
+	}
+	if !ok1 || !ok2 || id1 == id2 {
+		return metadataDiff{}, false
+	}
+
+	return metadataDiff{
+		result:      kDifferentProjectID,
+		description: fmt.Sprintf("file1 has project ID %d, file2 has %d", id1, id2),
+	}, true
+}
@@ -0,0 +1,48 @@
+//go:build linux && (386 || amd64 || arm || arm64 || riscv64 || s390x)
+// +build linux
+// +build 386 amd64 arm arm64 riscv64 s390x
+
+package difftreelib
+
+import (
+	"errors"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// struct fsxattr, from linux/fs.h
+type fsxattr struct {
+	xflags     uint32
+	extsize    uint32
+	nextents   uint32
+	projid     uint32
+	cowextsize uint32
+	pad        [8]byte
+}
+
+// _IOR('X', 31, struct fsxattr), on the architectures above, which
+// share the generic ioctl encoding
+const fsIocFsGetXattr = 0x801c581f
+
+// The project ID of a regular file or directory, as XFS and ext4
+// record it. Returns false if the filesystem has no project IDs.
+func getProjectID(path string) (uint32, bool, error) {
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return 0, false, err
+	}
+	defer unix.Close(fd)
+
+	var attr fsxattr
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), fsIocFsGetXattr,
+		uintptr(unsafe.Pointer(&attr)))
+	if errno != 0 {
+		if errors.Is(errno, unix.ENOTTY) || errors.Is(errno, unix.ENOTSUP) ||
+			errors.Is(errno, unix.EINVAL) {
+			return 0, false, nil
+		}
+		return 0, false, errno
+	}
+	return attr.projid, true, nil
+}
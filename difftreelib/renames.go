@@ -0,0 +1,170 @@
+package difftreelib
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A regular file which is only in one of the trees, and may have
+// been moved to, or from, another path in the other tree.
+type renameCandidate struct {
+	relPath string
+	path    string
+	size    int64
+	hash    []byte
+	matched bool
+
+	// For a missing file, which is reported as missing again if it
+	// isn't matched, and may then be applied
+	path2 string
+	info  os.FileInfo
+}
+
+// With DetectRenames, hold back the results for missing files, and
+// collect the files which are only in tree2, so that they can be
+// matched by content after the walk. Returns true if the entry was
+// held back.
+func (s *ComparisonEngine) collectRenameCandidates(entry *treeEntry) bool {
+	if s.reportingRenames {
+		return false
+	}
+	switch entry.result {
+	case kMissing:
+		if entry.info1 == nil || !entry.info1.Mode().IsRegular() {
+			return false
+		}
+		s.missingFiles = append(s.missingFiles, &renameCandidate{
+			relPath: s.relativePath(entry),
+			path:    entry.path1,
+			size:    entry.info1.Size(),
+			path2:   entry.path2,
+			info:    entry.info1,
+		})
+		return true
+
+	case kDirDifferentEntries, kDirDifferentDotfiles:
+		if entry.dir2Extra == nil {
+			return false
+		}
+		for _, item := range entry.dir2Extra.ToSlice() {
+			name := item.(string)
+			s.collectExtraFiles(filepath.Join(entry.path2, name),
+				filepath.Join(entry.relPath, name))
+		}
+	}
+	return false
+}
+
+// Collect the regular files at or under path, which is only in tree2
+func (s *ComparisonEngine) collectExtraFiles(path string, relPath string) {
+	/* (void) */
+	filepath.Walk(path, func(walkPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Keep going
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		s.extraFiles = append(s.extraFiles, &renameCandidate{
+			relPath: filepath.Join(relPath, walkPath[len(path):]),
+			path:    walkPath,
+			size:    info.Size(),
+		})
+		return nil
+	})
+}
+
+func (self *renameCandidate) getHash(options *DifftreeOptions) []byte {
+	if self.hash == nil {
+		hash, err := getFileHash(self.path, options)
+		if err != nil {
+			return nil
+		}
+		self.hash = hash
+	}
+	return self.hash
+}
+
+// Find an unmatched extra file with the same content as the missing
+// file, for which accept is true
+func (self *renameCandidate) findMatch(extras []*renameCandidate, options *DifftreeOptions,
+	accept func(*renameCandidate) bool) *renameCandidate {
+
+	for _, extra := range extras {
+		if extra.matched || !accept(extra) {
+			continue
+		}
+		hash1 := self.getHash(options)
+		hash2 := extra.getHash(options)
+		if hash1 == nil || hash2 == nil || !bytes.Equal(hash1, hash2) {
+			continue
+		}
+		return extra
+	}
+	return nil
+}
+
+// Whether the two paths are in the same directory, with names which
+// differ only in case
+func isCaseRename(relPath1 string, relPath2 string) bool {
+	return filepath.Dir(relPath1) == filepath.Dir(relPath2) &&
+		strings.EqualFold(filepath.Base(relPath1), filepath.Base(relPath2))
+}
+
+// Match each missing file to an extra file with the same content,
+// and report it as renamed in case, or moved; report the rest as
+// missing. Renames in case are matched first.
+func (s *ComparisonEngine) reportRenames(options *DifftreeOptions) {
+	extrasBySize := make(map[int64][]*renameCandidate)
+	for _, extra := range s.extraFiles {
+		extrasBySize[extra.size] = append(extrasBySize[extra.size], extra)
+	}
+
+	s.reportingRenames = true
+	sort.Slice(s.missingFiles, func(i, j int) bool {
+		return s.missingFiles[i].relPath < s.missingFiles[j].relPath
+	})
+
+	for _, missing := range s.missingFiles {
+		entry := treeEntry{
+			path1:   missing.path,
+			path2:   missing.path2,
+			relPath: missing.relPath,
+			info1:   missing.info,
+			result:  kMissing,
+		}
+
+		candidates := extrasBySize[missing.size]
+		if options.DetectCaseRenames {
+			extra := missing.findMatch(candidates, options, func(extra *renameCandidate) bool {
+				return isCaseRename(missing.relPath, extra.relPath)
+			})
+			if extra != nil {
+				extra.matched = true
+				entry.result = kCaseRename
+				entry.description = fmt.Sprintf("renamed from %s to %s in tree2",
+					filepath.Base(missing.relPath), filepath.Base(extra.relPath))
+			}
+		}
+		if entry.result == kMissing && options.DetectRenames {
+			extra := missing.findMatch(candidates, options, func(*renameCandidate) bool {
+				return true
+			})
+			if extra != nil {
+				extra.matched = true
+				entry.result = kMoved
+				entry.description = fmt.Sprintf("moved to %s in tree2", extra.relPath)
+			}
+		}
+
+		s.handleResult(&entry, options)
+	}
+
+	s.missingFiles = nil
+	s.extraFiles = nil
+}
@@ -0,0 +1,54 @@
+package difftreelib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareTwiceOnOneEngine(t *testing.T) {
+	differentPath1, differentPath2, cleanup := makeTrees(t,
+		testTree{"same": "1", "mismatch": "1", "missing": "1", "dir/": ""},
+		testTree{"same": "1", "mismatch": "22", "extra": "1"})
+	defer cleanup()
+	samePath1, samePath2, cleanup := makeTrees(t,
+		testTree{"file": "1", "dir/file": "2"},
+		testTree{"file": "1", "dir/file": "2"})
+	defer cleanup()
+
+	tests := []struct {
+		name         string
+		first        [2]string
+		second       [2]string
+		firstOptions DifftreeOptions
+	}{
+		{"differences, then none", [2]string{differentPath1, differentPath2},
+			[2]string{samePath1, samePath2}, DifftreeOptions{}},
+		{"none, then differences", [2]string{samePath1, samePath2},
+			[2]string{differentPath1, differentPath2}, DifftreeOptions{}},
+		{"stopped at a difference, then none", [2]string{differentPath1, differentPath2},
+			[2]string{samePath1, samePath2}, DifftreeOptions{StopOnFirstDiff: true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var fresh ComparisonEngine
+			options := DifftreeOptions{Quiet: true}
+			if err := fresh.Compare(test.second[0], test.second[1], &options); err != nil {
+				t.Fatalf("Compare: %v", err)
+			}
+
+			var reused ComparisonEngine
+			firstOptions := test.firstOptions
+			firstOptions.Quiet = true
+			if err := reused.Compare(test.first[0], test.first[1], &firstOptions); err != nil {
+				t.Fatalf("Compare: %v", err)
+			}
+			if err := reused.Compare(test.second[0], test.second[1], &options); err != nil {
+				t.Fatalf("Compare: %v", err)
+			}
+
+			if got, want := reused.Stats(), fresh.Stats(); !reflect.DeepEqual(got, want) {
+				t.Errorf("second comparison's stats = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package difftreelib
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// Each result is a row in the "results" table of ResultsDB. The sizes
+// are only for regular files, and the hashes only for files which
+// were hashed; otherwise, they are NULL.
+const createResultsTable = `CREATE TABLE IF NOT EXISTS results (
+	path        TEXT NOT NULL,
+	result      TEXT NOT NULL,
+	description TEXT,
+	size1       INTEGER,
+	size2       INTEGER,
+	hash1       TEXT,
+	hash2       TEXT
+)`
+
+const insertResult = `INSERT INTO results
+	(path, result, description, size1, size2, hash1, hash2)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+// The rows are inserted in transactions of this many rows, as
+// committing each one separately is very slow.
+const resultsBatchSize = 1000
+
+type resultsDBWriter struct {
+	db      *sql.DB
+	tx      *sql.Tx
+	insert  *sql.Stmt
+	pending int
+}
+
+func newResultsDBWriter(db *sql.DB) (*resultsDBWriter, error) {
+	_, err := db.Exec(createResultsTable)
+	if err != nil {
+		return nil, fmt.Errorf("Creating the results table: %w", err)
+	}
+	self := &resultsDBWriter{db: db}
+	err = self.begin()
+	if err != nil {
+		return nil, err
+	}
+	return self, nil
+}
+
+func (self *resultsDBWriter) begin() error {
+	var err error
+	self.tx, err = self.db.Begin()
+	if err != nil {
+		return err
+	}
+	self.insert, err = self.tx.Prepare(insertResult)
+	if err != nil {
+		self.tx.Rollback()
+		return err
+	}
+	self.pending = 0
+	return nil
+}
+
+func (self *resultsDBWriter) commit() error {
+	self.insert.Close()
+	return self.tx.Commit()
+}
+
+func regularFileSize(info os.FileInfo) sql.NullInt64 {
+	if info == nil || !info.Mode().IsRegular() {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: info.Size(), Valid: true}
+}
+
+func hashString(hash []byte) sql.NullString {
+	if hash == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: hex.EncodeToString(hash), Valid: true}
+}
+
+func (self *resultsDBWriter) write(relativePath string, entry *treeEntry) {
+	description := entry.description
+	if entry.err != nil {
+		description = entry.err.Error()
+	}
+	var info2 os.FileInfo
+	if entry.hasInfo2 {
+		info2 = entry.info2
+	}
+
+	_, err := self.insert.Exec(relativePath, entry.result.String(), description,
+		regularFileSize(entry.info1), regularFileSize(info2),
+		hashString(entry.hash1), hashString(entry.hash2))
+	if err != nil {
+		logError("Cannot write %s to the results database: %v", relativePath, err)
+		return
+	}
+
+	self.pending++
+	if self.pending >= resultsBatchSize {
+		err = self.commit()
+		if err == nil {
+			err = self.begin()
+		}
+		if err != nil {
+			logError("Cannot write to the results database: %v", err)
+		}
+	}
+}
+
+// Commit the last batch of rows
+func (self *resultsDBWriter) close() error {
+	return self.commit()
+}
@@ -0,0 +1,26 @@
+package difftreelib
+
+import (
+	"time"
+)
+
+// Run op, retrying it up to MaxRetries times if it fails with a
+// retryable error. The wait between attempts starts at RetryBackoff
+// and doubles after each attempt.
+func (self *DifftreeOptions) retry(op func() error) error {
+	isRetryable := self.IsRetryableError
+	if isRetryable == nil {
+		isRetryable = isRetryableError
+	}
+
+	backoff := self.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= self.MaxRetries || !isRetryable(err) {
+			return err
+		}
+		logInfo("Retrying after error: %v", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
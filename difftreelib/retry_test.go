@@ -0,0 +1,120 @@
+package difftreelib
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var errFlaky = errors.New("flaky")
+
+// A filesystem operation which fails the first few times it is run
+type flakyOp struct {
+	failures int
+	err      error
+	calls    int
+}
+
+func (self *flakyOp) run() error {
+	self.calls++
+	if self.calls <= self.failures {
+		return &os.PathError{Op: "lstat", Path: "file", Err: self.err}
+	}
+	return nil
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name        string
+		failures    int
+		err         error
+		maxRetries  int
+		isRetryable func(error) bool
+		wantErr     bool
+		wantCalls   int
+	}{
+		{"no failures", 0, syscall.EINTR, 3, nil, false, 1},
+		{"no retries", 1, syscall.EINTR, 0, nil, true, 1},
+		{"fewer failures than retries", 2, syscall.EINTR, 3, nil, false, 3},
+		{"as many failures as retries", 3, syscall.EINTR, 3, nil, false, 4},
+		{"more failures than retries", 4, syscall.EINTR, 3, nil, true, 4},
+		{"not retryable", 2, errFlaky, 3, nil, true, 1},
+		{"retryable by the caller", 2, errFlaky, 3,
+			func(err error) bool { return errors.Is(err, errFlaky) }, false, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			op := flakyOp{failures: test.failures, err: test.err}
+			options := DifftreeOptions{
+				MaxRetries:       test.maxRetries,
+				IsRetryableError: test.isRetryable,
+			}
+			err := options.retry(op.run)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("retry() = %v, want an error: %v", err, test.wantErr)
+			}
+			if op.calls != test.wantCalls {
+				t.Errorf("%d calls, want %d", op.calls, test.wantCalls)
+			}
+		})
+	}
+}
+
+// An openFile which fails with EINTR the first few times each file is
+// opened
+type flakyOpen struct {
+	failures int
+	mutex    sync.Mutex
+	calls    map[string]int
+}
+
+func (self *flakyOpen) open(name string) (*os.File, error) {
+	self.mutex.Lock()
+	self.calls[name]++
+	calls := self.calls[name]
+	self.mutex.Unlock()
+	if calls <= self.failures {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EINTR}
+	}
+	return os.Open(name)
+}
+
+// Files which can't be opened at first are hashed once they can be,
+// within MaxRetries
+func TestRetryHashing(t *testing.T) {
+	path1, path2, cleanup := makeTrees(t, testTree{"file": "abc"}, testTree{"file": "abc"})
+	defer cleanup()
+
+	tests := []struct {
+		name      string
+		failures  int
+		wantMatch int
+		wantError int
+	}{
+		{"no failures", 0, 1, 0},
+		{"as many failures as retries", 2, 1, 0},
+		{"more failures than retries", 3, 0, 1},
+	}
+	for _, hashAlgorithm := range []string{HashSHA1, HashBLAKE3} {
+		for _, test := range tests {
+			t.Run(hashAlgorithm+" "+test.name, func(t *testing.T) {
+				open := flakyOpen{failures: test.failures, calls: make(map[string]int)}
+				defer replaceOpenFile(open.open)()
+				options := DifftreeOptions{
+					CheckHashes:   true,
+					HashAlgorithm: hashAlgorithm,
+					MaxRetries:    2,
+					RetryBackoff:  time.Millisecond,
+				}
+				stats := compareQuietly(t, path1, path2, &options)
+				if stats.PerfectMatch != test.wantMatch || stats.Error != test.wantError {
+					t.Errorf("%d perfect matches and %d errors, want %d and %d",
+						stats.PerfectMatch, stats.Error, test.wantMatch, test.wantError)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build plan9
+// +build plan9
+
+package difftreelib
+
+import (
+	"errors"
+	"syscall"
+)
+
+// The default test for errors worth retrying: an interrupted system
+// call. Plan 9 has no stale handles.
+func isRetryableError(err error) bool {
+	return errors.Is(err, syscall.EINTR)
+}
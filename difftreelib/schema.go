@@ -0,0 +1,113 @@
+package difftreelib
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Write a JSON Schema describing the objects of the Events stream,
+// one of which is on each line, and the Stats object, which is also
+// what -stats-json writes. It is derived from the structs which are
+// marshalled, so it stays in sync with them.
+func WriteSchema(writer io.Writer) error {
+	var tags []string
+	for result, tag := range resultTags {
+		if result != kNil {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+
+	result := structSchema(reflect.TypeOf(resultEvent{}))
+	result["properties"].(map[string]interface{})["result"] = map[string]interface{}{
+		"type": "string",
+		"enum": tags,
+	}
+
+	schema := map[string]interface{}{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"title":       "difftree events",
+		"description": "Each line of the -events output is one of these objects",
+		"oneOf": []interface{}{
+			map[string]interface{}{"$ref": "#/$defs/result"},
+			map[string]interface{}{"$ref": "#/$defs/progress"},
+			map[string]interface{}{"$ref": "#/$defs/summary"},
+		},
+		"$defs": map[string]interface{}{
+			"result":   withEventType(result, "result"),
+			"progress": withEventType(structSchema(reflect.TypeOf(progressEvent{})), "progress"),
+			"summary":  withEventType(structSchema(reflect.TypeOf(summaryEvent{})), "summary"),
+			"stats":    structSchema(reflect.TypeOf(Stats{})),
+		},
+	}
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(append(data, '\n'))
+	return err
+}
+
+// Fix the "type" key of an event to its name
+func withEventType(schema map[string]interface{}, eventType string) map[string]interface{} {
+	schema["properties"].(map[string]interface{})["type"] = map[string]interface{}{
+		"const": eventType,
+	}
+	return schema
+}
+
+// The schema of a struct, from the names in its json tags. Fields
+// without omitempty are required.
+func structSchema(structType reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := []string{}
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		parts := strings.Split(field.Tag.Get("json"), ",")
+		name := parts[0]
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type)
+
+		omitempty := false
+		for _, option := range parts[1:] {
+			if option == "omitempty" {
+				omitempty = true
+			}
+		}
+		if !omitempty {
+			required = append(required, name)
+		}
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+func typeSchema(fieldType reflect.Type) map[string]interface{} {
+	switch fieldType.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Struct:
+		if fieldType == reflect.TypeOf(Stats{}) {
+			return map[string]interface{}{"$ref": "#/$defs/stats"}
+		}
+		return structSchema(fieldType)
+	default:
+		return map[string]interface{}{"type": "string"}
+	}
+}
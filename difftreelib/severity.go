@@ -0,0 +1,108 @@
+package difftreelib
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// How far apart two trees are, from a comparison's counts, for
+// an at-a-glance classification. Each level is worse than the ones
+// before it.
+type Severity int
+
+const (
+	SeverityIdentical Severity = iota
+	SeverityMetadataOnly
+	SeverityContentDrift
+	SeverityStructuralDrift
+	SeverityErrors
+)
+
+var severityNames = map[Severity]string{
+	SeverityIdentical:       "identical",
+	SeverityMetadataOnly:    "metadata-only",
+	SeverityContentDrift:    "content-drift",
+	SeverityStructuralDrift: "structural-drift",
+	SeverityErrors:          "errors",
+}
+
+func (self Severity) String() string {
+	return severityNames[self]
+}
+
+// The worst level which any count reaches:
+//
+//	errors: any errors while reading.
+//	structural-drift: missing, extra, moved or renamed paths, paths of
+//	  different types, and directories with different entries.
+//	content-drift: different or truncated contents, content types or
+//	  symlink targets, and symlinks escaping their tree.
+//	metadata-only: different perms, owners, xattrs, birthtimes,
+//	  project IDs, inode generations or other metadata, directory
+//	  mtimes, and suspicious permissions.
+//	identical: none of the above.
+//
+// Matches after normalization, allowed permission changes, ignored and
+// baselined paths, and paths not sampled, don't count as drift.
+func (self Stats) Severity() Severity {
+	switch {
+	case self.Error > 0:
+		return SeverityErrors
+
+	case self.Missing > 0 || self.Extra > 0 || self.Moved > 0 || self.CaseRenamed > 0 ||
+		self.DifferentTypes > 0 || self.DirDifferent > 0 || self.DirDifferentAttrs > 0 ||
+		self.DirDifferentDotfiles > 0:
+		return SeverityStructuralDrift
+
+	case self.Mismatch > 0 || self.Truncated > 0 || self.DifferentContentTypes > 0 ||
+		self.DifferentTargets > 0 || self.SameResolvedContent > 0 ||
+		self.DifferentResolvedContent > 0 || self.EscapingSymlinks > 0:
+		return SeverityContentDrift
+
+	case self.DifferentPerms > 0 || self.DifferentOwners > 0 ||
+		self.DifferentXattrs > 0 || self.DifferentBirthtime > 0 ||
+		self.DifferentProjectIDs > 0 || self.DifferentGenerations > 0 ||
+		self.MetadataDiffers > 0 || self.DirDifferentMtime > 0 ||
+		self.SuspiciousPerms > 0:
+		return SeverityMetadataOnly
+	}
+	return SeverityIdentical
+}
+
+// The severity of the comparison's counts, without the results in
+// DifftreeOptions.IgnoreForExit, for deciding whether it failed
+func (s *ComparisonEngine) ExitSeverity() Severity {
+	return s.stats.minus(s.exitIgnored).Severity()
+}
+
+// The counts of self less those of other
+func (self Stats) minus(other Stats) Stats {
+	selfValue := reflect.ValueOf(&self).Elem()
+	otherValue := reflect.ValueOf(other)
+	for i := 0; i < selfValue.NumField(); i++ {
+		field := selfValue.Field(i)
+		if field.Kind() == reflect.Int {
+			field.SetInt(field.Int() - otherValue.Field(i).Int())
+		}
+	}
+	return self
+}
+
+// The results with these tags, which must be known
+func parseResultTags(tags []string) (map[resultType]bool, error) {
+	byTag := make(map[string]resultType, len(resultTags))
+	for result, tag := range resultTags {
+		if result != kNil {
+			byTag[tag] = result
+		}
+	}
+	results := make(map[resultType]bool, len(tags))
+	for _, tag := range tags {
+		result, has := byTag[tag]
+		if !has {
+			return nil, fmt.Errorf("Unknown result %q", tag)
+		}
+		results[result] = true
+	}
+	return results, nil
+}
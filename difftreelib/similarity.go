@@ -0,0 +1,114 @@
+package difftreelib
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+)
+
+// Files are split into chunks where a rolling "gear" hash of the last
+// bytes has its low bits all zero, as in FastCDC. As the boundaries
+// depend only on the nearby content, an insertion or deletion changes
+// only the chunks around it, and the rest are still shared.
+const (
+	minChunkSize = 2 << 10
+	maxChunkSize = 64 << 10
+	// For chunks of about 8KB, on average
+	chunkBoundaryMask = 1<<13 - 1
+)
+
+// The same random values each run, so the chunks are repeatable
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	random := rand.New(rand.NewSource(1))
+	for i := range table {
+		table[i] = random.Uint64()
+	}
+	return table
+}()
+
+// The size of each distinct chunk of a file, by the chunk's SHA1
+type fileChunks map[[sha1.Size]byte]int64
+
+func chunkFile(filename string) (fileChunks, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	chunks := make(fileChunks)
+	reader := bufio.NewReaderSize(f, maxChunkSize)
+	chunk := make([]byte, 0, maxChunkSize)
+	var gear uint64
+	for {
+		b, err := reader.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Reading %s: %w", filename, err)
+		}
+		chunk = append(chunk, b)
+		gear = gear<<1 + gearTable[b]
+
+		if (len(chunk) >= minChunkSize && gear&chunkBoundaryMask == 0) ||
+			len(chunk) == maxChunkSize {
+			chunks[sha1.Sum(chunk)] = int64(len(chunk))
+			chunk = chunk[:0]
+			gear = 0
+		}
+	}
+	if len(chunk) > 0 {
+		chunks[sha1.Sum(chunk)] = int64(len(chunk))
+	}
+	return chunks, nil
+}
+
+// The fraction of the two files' distinct chunks, by size, which they
+// share, from 0 (nothing in common) to 1
+func chunkSimilarity(chunks1 fileChunks, chunks2 fileChunks) float64 {
+	var total, shared int64
+	for digest, size := range chunks1 {
+		total += size
+		if _, has := chunks2[digest]; has {
+			shared += 2 * size
+		}
+	}
+	for _, size := range chunks2 {
+		total += size
+	}
+	if total == 0 {
+		return 1
+	}
+	return float64(shared) / float64(total)
+}
+
+// Add how similar two mismatched files are to the description
+func (self *treeEntry) appendSimilarity(options *DifftreeOptions) {
+	if self.result != kMismatch {
+		return
+	}
+
+	var chunks1, chunks2 fileChunks
+	err := options.retry(func() error {
+		var err error
+		chunks1, err = chunkFile(self.path1)
+		if err != nil {
+			return err
+		}
+		chunks2, err = chunkFile(self.path2)
+		return err
+	})
+	if err != nil {
+		logError("Cannot measure the similarity of %s: %v", self.path1, err)
+		return
+	}
+	self.bytesRead += self.info1.Size() + self.info2.Size()
+
+	self.description += fmt.Sprintf(" (%.1f%% similar)",
+		100*chunkSimilarity(chunks1, chunks2))
+}
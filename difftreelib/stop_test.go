@@ -0,0 +1,52 @@
+package difftreelib
+
+import (
+	"testing"
+)
+
+func TestStopBeforeCompare(t *testing.T) {
+	path1, path2, cleanup := makeTrees(t,
+		testTree{"file": "1", "dir/file": "1"},
+		testTree{"file": "1", "dir/file": "1"})
+	defer cleanup()
+
+	var engine ComparisonEngine
+	engine.Stop()
+	options := DifftreeOptions{Quiet: true}
+	if err := engine.Compare(path1, path2, &options); err != nil {
+		t.Fatalf("Compare: %v", err)
+	}
+	stats := engine.Stats()
+	if !stats.Incomplete || stats.IncompleteReason != "the comparison was interrupted" {
+		t.Errorf("stopped before Compare: Incomplete = %v, IncompleteReason = %q",
+			stats.Incomplete, stats.IncompleteReason)
+	}
+
+	// The Stop was for that comparison only
+	if err := engine.Compare(path1, path2, &options); err != nil {
+		t.Fatalf("Compare: %v", err)
+	}
+	if stats := engine.Stats(); stats.Incomplete {
+		t.Errorf("the next comparison is incomplete: %q", stats.IncompleteReason)
+	}
+}
+
+// Run with -race: Stop may be called while Compare resets the engine
+func TestStopDuringCompare(t *testing.T) {
+	path1, path2, cleanup := makeTrees(t,
+		testTree{"file": "1"},
+		testTree{"file": "1"})
+	defer cleanup()
+
+	var engine ComparisonEngine
+	done := make(chan struct{})
+	go func() {
+		engine.Stop()
+		close(done)
+	}()
+	options := DifftreeOptions{Quiet: true}
+	if err := engine.Compare(path1, path2, &options); err != nil {
+		t.Fatalf("Compare: %v", err)
+	}
+	<-done
+}
@@ -0,0 +1,29 @@
+package difftreelib
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Returns true if either path has any of the FlagPermissions bits.
+// Symlinks are skipped, as their permissions aren't used.
+func (self *treeEntry) flagSuspiciousPerms(options *DifftreeOptions) bool {
+	var warnings []string
+	for i, info := range []os.FileInfo{self.info1, self.info2} {
+		if isLink(info) {
+			continue
+		}
+		if bits := info.Mode().Perm() & options.FlagPermissions; bits != 0 {
+			warnings = append(warnings, fmt.Sprintf(
+				"file%d has perms %s, with suspicious bits %04o",
+				i+1, options.formatPerms(info.Mode()), uint32(bits)))
+		}
+	}
+	if len(warnings) == 0 {
+		return false
+	}
+	self.result = kSuspiciousPerms
+	self.description = strings.Join(warnings, "; ")
+	return true
+}
@@ -0,0 +1,312 @@
+package difftreelib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Given a path inside a tree and its path relative to the tree root,
+// return the tree root. A path which is itself the root, as when
+// comparing two single files, is taken to be inside its parent.
+func treeRoot(path string, relPath string) string {
+	if relPath == "" {
+		return filepath.Dir(path)
+	}
+	return path[:len(path)-len(relPath)-1]
+}
+
+// The root of tree2. The entry's relPath is in tree1, so with PathMap,
+// path2 is at the mapped relPath in tree2 instead.
+func (self *treeEntry) tree2Root(options *DifftreeOptions) string {
+	relPath := self.relPath
+	if relPath != "" && options.PathMap != nil {
+		relPath = filepath.Clean(options.PathMap(relPath))
+		if relPath == "." {
+			return self.path2
+		}
+	}
+	return treeRoot(self.path2, relPath)
+}
+
+// Resolve a symlink's target to a path relative to its tree root.
+// This is purely lexical; the target need not exist.
+func resolveSymlinkTarget(linkPath string, target string, root string) string {
+	var resolved string
+	if filepath.IsAbs(target) {
+		resolved = filepath.Clean(target)
+	} else {
+		resolved = filepath.Join(filepath.Dir(linkPath), target)
+	}
+
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return resolved
+	}
+	absResolved, err := filepath.Abs(resolved)
+	if err != nil {
+		return resolved
+	}
+	rel, err := filepath.Rel(absRoot, absResolved)
+	if err != nil {
+		return resolved
+	}
+	return rel
+}
+
+func symlinkEscapesRoot(linkPath string, target string, root string) bool {
+	rel := resolveSymlinkTarget(linkPath, target, root)
+	return filepath.IsAbs(rel) || rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Whether the path is compared as a symlink, by its target. On
+// Windows, this includes directory junctions, which are otherwise
+// neither symlinks nor directories to the os package.
+func isLink(info os.FileInfo) bool {
+	return info.Mode()&os.ModeSymlink != 0 || isJunction(info)
+}
+
+func (self *treeEntry) compareSymlinks(options *DifftreeOptions) {
+	target1, err := os.Readlink(self.path1)
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return
+	}
+	target2, err := os.Readlink(self.path2)
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return
+	}
+
+	// Dangling on both sides; there's nothing to compare them by
+	// but their targets, and those are often expected to differ
+	if options.IgnoreBrokenSymlinks && isBrokenSymlink(self.path1) &&
+		isBrokenSymlink(self.path2) {
+		self.result = kIgnored
+		return
+	}
+
+	if options.FlagEscapingSymlinks {
+		var escapes []string
+		if symlinkEscapesRoot(self.path1, target1, treeRoot(self.path1, self.relPath)) {
+			escapes = append(escapes, fmt.Sprintf("file1 points to %q, outside tree1", target1))
+		}
+		if symlinkEscapesRoot(self.path2, target2, self.tree2Root(options)) {
+			escapes = append(escapes, fmt.Sprintf("file2 points to %q, outside tree2", target2))
+		}
+		if len(escapes) > 0 {
+			self.result = kEscapingSymlink
+			self.description = strings.Join(escapes, "; ")
+			return
+		}
+	}
+
+	if options.CompareResolvedContent && self.compareResolvedContent(options, target1, target2) {
+		return
+	}
+
+	if target1 == target2 {
+		self.result = kPerfectMatch
+		if options.VerifySymlinkTargets {
+			self.verifySymlinkTargets(options, target1)
+		}
+		return
+	}
+
+	if options.ResolveSymlinkTargets {
+		resolved1 := resolveSymlinkTarget(self.path1, target1,
+			treeRoot(self.path1, self.relPath))
+		resolved2 := resolveSymlinkTarget(self.path2, target2,
+			self.tree2Root(options))
+		if resolved1 == resolved2 {
+			self.result = kPerfectMatch
+			if options.VerifySymlinkTargets {
+				self.verifySymlinkTargets(options, target1)
+			}
+			return
+		}
+		self.result = kDifferentTargets
+		self.description = fmt.Sprintf(
+			"file1 points to %q (%s in tree1), file2 points to %q (%s in tree2)",
+			target1, resolved1, target2, resolved2)
+		return
+	}
+
+	self.result = kDifferentTargets
+	self.description = fmt.Sprintf("file1 points to %q, file2 points to %q",
+		target1, target2)
+}
+
+// Whether the symlink's target doesn't exist, or can't be reached,
+// as with a loop
+func isBrokenSymlink(linkPath string) bool {
+	_, err := os.Stat(linkPath)
+	return err != nil
+}
+
+// Follow a symlink, and any symlinks it leads to, to a regular file.
+// filepath.EvalSymlinks gives up on a loop.
+func resolveToRegularFile(linkPath string) (string, bool) {
+	resolved, err := filepath.EvalSymlinks(linkPath)
+	if err != nil {
+		logError("Cannot resolve %s: %v", linkPath, err)
+		return "", false
+	}
+	info, err := os.Stat(resolved)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	return resolved, true
+}
+
+// Compare the contents of the regular files which the two symlinks
+// finally resolve to. This returns true if it decided the result;
+// if either doesn't resolve to a regular file, the targets are
+// compared as usual.
+func (self *treeEntry) compareResolvedContent(options *DifftreeOptions,
+	target1 string, target2 string) bool {
+
+	resolved1, ok1 := resolveToRegularFile(self.path1)
+	resolved2, ok2 := resolveToRegularFile(self.path2)
+	if !ok1 || !ok2 {
+		return false
+	}
+
+	hash1, err := getFileHash(resolved1, options)
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return true
+	}
+	hash2, err := getFileHash(resolved2, options)
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return true
+	}
+	sameContent := cmpByteSlices(hash1, hash2)
+
+	switch {
+	case target1 == target2 && sameContent:
+		self.result = kPerfectMatch
+	case target1 == target2:
+		self.result = kDifferentResolvedContent
+		self.description = fmt.Sprintf(
+			"both point to %q, but file1 resolves to %s and file2 to %s, whose contents differ",
+			target1, resolved1, resolved2)
+	case sameContent:
+		self.result = kSameResolvedContent
+		self.description = fmt.Sprintf(
+			"file1 points to %q, file2 points to %q, but both resolve to the same contents",
+			target1, target2)
+	default:
+		self.result = kDifferentTargets
+		self.description = fmt.Sprintf(
+			"file1 points to %q, file2 points to %q, and they resolve to different contents",
+			target1, target2)
+	}
+	return true
+}
+
+// Follow a symlink, and any symlinks it leads to, to the path it
+// finally names, which must be inside the tree. Otherwise, returns
+// what is wrong with it.
+func resolveInTree(linkPath string, root string, name string, treeName string) (string, string) {
+	resolved, err := filepath.EvalSymlinks(linkPath)
+	if os.IsNotExist(err) {
+		return "", fmt.Sprintf("%s's target doesn't exist", name)
+	} else if err != nil {
+		// Including a loop
+		return "", fmt.Sprintf("%s's target can't be resolved: %v", name, err)
+	}
+
+	// The root may be reached through symlinks itself
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		realRoot = root
+	}
+	rel, err := filepath.Rel(realRoot, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Sprintf("%s resolves to %s, outside %s", name, resolved, treeName)
+	}
+	return resolved, ""
+}
+
+// With VerifySymlinkTargets, two symlinks which match are only a
+// match if what they point to does too: both must resolve inside
+// their trees to regular files with the same contents, or to
+// directories, whose contents are compared by the walk.
+func (self *treeEntry) verifySymlinkTargets(options *DifftreeOptions, target string) {
+	resolved1, problem1 := resolveInTree(self.path1, treeRoot(self.path1, self.relPath),
+		"file1", "tree1")
+	resolved2, problem2 := resolveInTree(self.path2, self.tree2Root(options),
+		"file2", "tree2")
+	var problems []string
+	for _, problem := range []string{problem1, problem2} {
+		if problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) > 0 {
+		self.result = kDifferentResolvedContent
+		self.description = fmt.Sprintf("both point to %q, but %s", target,
+			strings.Join(problems, "; "))
+		return
+	}
+
+	info1, err := os.Stat(resolved1)
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return
+	}
+	info2, err := os.Stat(resolved2)
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return
+	}
+
+	switch {
+	case info1.IsDir() && info2.IsDir():
+		self.result = kPerfectMatch
+	case info1.Mode().IsRegular() && info2.Mode().IsRegular():
+		if info1.Size() != info2.Size() {
+			self.result = kDifferentResolvedContent
+			self.description = fmt.Sprintf("both point to %q, but file1 resolves to %s and file2 to %s: %s",
+				target, resolved1, resolved2, describeSizes(info1.Size(), info2.Size()))
+			return
+		}
+		hash1, err := getFileHash(resolved1, options)
+		if err != nil {
+			self.result = kError
+			self.err = err
+			return
+		}
+		hash2, err := getFileHash(resolved2, options)
+		if err != nil {
+			self.result = kError
+			self.err = err
+			return
+		}
+		self.bytesRead += info1.Size() + info2.Size()
+		if cmpByteSlices(hash1, hash2) {
+			self.result = kPerfectMatch
+			return
+		}
+		self.result = kDifferentResolvedContent
+		self.description = fmt.Sprintf(
+			"both point to %q, but file1 resolves to %s and file2 to %s, whose contents differ",
+			target, resolved1, resolved2)
+	default:
+		self.result = kDifferentResolvedContent
+		self.description = fmt.Sprintf(
+			"both point to %q, but file1 resolves to a %s and file2 to a %s",
+			target, translateModeType(info1.Mode()&os.ModeType),
+			translateModeType(info2.Mode()&os.ModeType))
+	}
+}
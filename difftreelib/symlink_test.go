@@ -0,0 +1,109 @@
+//go:build !windows
+// +build !windows
+
+package difftreelib
+
+import (
+	"testing"
+)
+
+func TestEscapingSymlinksWithPathMap(t *testing.T) {
+	tests := []struct {
+		name         string
+		target1      string
+		target2      string
+		wantEscaping int
+	}{
+		{"both inside", "../../file", "../file", 0},
+		{"tree2 escapes", "../../file", "../../outside", 1},
+		{"tree1 escapes", "../../../../outside", "../file", 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path1, path2, cleanup := makeTrees(t,
+				testTree{
+					"v1/file":          "x",
+					"v1/deep/dir/link": symlinkTo + test.target1,
+				},
+				testTree{
+					"file":   "x",
+					"x/link": symlinkTo + test.target2,
+				})
+			defer cleanup()
+
+			options := DifftreeOptions{
+				FlagEscapingSymlinks:  true,
+				ResolveSymlinkTargets: true,
+				VerifySymlinkTargets:  true,
+				PathMap:               PrefixPathMap([]PrefixRule{{From: "v1/deep/dir", To: "x"}}),
+			}
+			stats := compareQuietly(t, path1, path2, &options)
+			if stats.EscapingSymlinks != test.wantEscaping {
+				t.Errorf("EscapingSymlinks = %d, want %d", stats.EscapingSymlinks,
+					test.wantEscaping)
+			}
+		})
+	}
+}
+
+func TestTree2Root(t *testing.T) {
+	tests := []struct {
+		name    string
+		path2   string
+		relPath string
+		rules   []PrefixRule
+		want    string
+	}{
+		{"unmapped", "b/x/y", "x/y", nil, "b"},
+		{"shorter", "b/x/link", "v1/deep/dir/link", []PrefixRule{{"v1/deep/dir", "x"}}, "b"},
+		{"longer", "b/a/b/c/link", "x/link", []PrefixRule{{"x", "a/b/c"}}, "b"},
+		{"to the root", "b", "x", []PrefixRule{{"x", ""}}, "b"},
+		{"the root itself", "b/file", "", nil, "b"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var options DifftreeOptions
+			if test.rules != nil {
+				options.PathMap = PrefixPathMap(test.rules)
+			}
+			entry := treeEntry{path2: test.path2, relPath: test.relPath}
+			if got := entry.tree2Root(&options); got != test.want {
+				t.Errorf("tree2Root() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIgnoreBrokenSymlinks(t *testing.T) {
+	tests := []struct {
+		name          string
+		target1       string
+		target2       string
+		ignoreBroken  bool
+		wantIgnored   int
+		wantDifferent int
+	}{
+		{"both dangling", "nowhere1", "nowhere2", true, 1, 0},
+		{"both dangling, not ignored", "nowhere1", "nowhere2", false, 0, 1},
+		{"only one dangling", "file", "nowhere", true, 0, 1},
+		{"neither dangling", "file", "other", true, 0, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path1, path2, cleanup := makeTrees(t,
+				testTree{"file": "1", "other": "1", "link": symlinkTo + test.target1},
+				testTree{"file": "1", "other": "1", "link": symlinkTo + test.target2})
+			defer cleanup()
+
+			options := DifftreeOptions{IgnoreBrokenSymlinks: test.ignoreBroken}
+			stats := compareQuietly(t, path1, path2, &options)
+			if stats.IgnoredByUser != test.wantIgnored {
+				t.Errorf("IgnoredByUser = %d, want %d", stats.IgnoredByUser, test.wantIgnored)
+			}
+			if stats.DifferentTargets != test.wantDifferent {
+				t.Errorf("DifferentTargets = %d, want %d", stats.DifferentTargets,
+					test.wantDifferent)
+			}
+		})
+	}
+}
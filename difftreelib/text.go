@@ -0,0 +1,174 @@
+package difftreelib
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+var (
+	crlf    = []byte("\r\n")
+	lf      = []byte("\n")
+	utf8BOM = []byte("\xef\xbb\xbf")
+)
+
+// The default cap on the size of files whose contents are read into
+// memory for text handling.
+const defaultTextSizeLimit = 4 << 10
+
+// Like diff and grep, guess that data with a NUL byte is binary.
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data, 0) != -1
+}
+
+func (self *DifftreeOptions) textSizeLimit() int64 {
+	if self.TextSizeLimit > 0 {
+		return self.TextSizeLimit
+	}
+	return defaultTextSizeLimit
+}
+
+// Add both files' contents to the description of a mismatch, if they
+// are small text files.
+func (self *treeEntry) appendSmallFileContents(options *DifftreeOptions) {
+	if self.result != kMismatch {
+		return
+	}
+	limit := options.textSizeLimit()
+	if self.info1.Size() > limit || self.info2.Size() > limit {
+		return
+	}
+
+	data1, err := readFile(self.path1, options)
+	if err != nil || isBinary(data1) {
+		return
+	}
+	data2, err := readFile(self.path2, options)
+	if err != nil || isBinary(data2) {
+		return
+	}
+	self.bytesRead += int64(len(data1) + len(data2))
+
+	self.description += fmt.Sprintf(
+		"\n--- file1 contents:\n%s\n--- file2 contents:\n%s",
+		strings.TrimSuffix(string(data1), "\n"),
+		strings.TrimSuffix(string(data2), "\n"))
+}
+
+func (self *DifftreeOptions) normalizesText() bool {
+	return self.NormalizeLineEndings || self.CaseInsensitiveContent ||
+		self.IgnoreTrailingWhitespace || self.IgnoreBOM
+}
+
+// Apply the enabled text normalizations, returning the normalized
+// data and the names of the ones which changed it.
+func normalizeText(data []byte, options *DifftreeOptions) ([]byte, []string) {
+	var applied []string
+	if options.IgnoreBOM && bytes.HasPrefix(data, utf8BOM) {
+		data = data[len(utf8BOM):]
+		applied = append(applied, "BOM")
+	}
+	if options.NormalizeLineEndings && bytes.Contains(data, crlf) {
+		data = bytes.Replace(data, crlf, lf, -1)
+		applied = append(applied, "line endings")
+	}
+	if options.IgnoreTrailingWhitespace {
+		trimmed := trimTrailingWhitespace(data)
+		if len(trimmed) != len(data) {
+			data = trimmed
+			applied = append(applied, "trailing whitespace")
+		}
+	}
+	if options.CaseInsensitiveContent {
+		lower := bytes.ToLower(data)
+		if !bytes.Equal(lower, data) {
+			data = lower
+			applied = append(applied, "case")
+		}
+	}
+	return data, applied
+}
+
+// Remove the spaces and tabs at the end of each line, keeping the
+// line endings, CR and all
+func trimTrailingWhitespace(data []byte) []byte {
+	trimmed := make([]byte, 0, len(data))
+	for len(data) > 0 {
+		var line, ending []byte
+		if i := bytes.IndexByte(data, '\n'); i != -1 {
+			line, data = data[:i], data[i+1:]
+			ending = lf
+			if bytes.HasSuffix(line, []byte("\r")) {
+				line = line[:len(line)-1]
+				ending = crlf
+			}
+		} else {
+			line, data = data, nil
+		}
+		trimmed = append(trimmed, bytes.TrimRight(line, " \t")...)
+		trimmed = append(trimmed, ending...)
+	}
+	return trimmed
+}
+
+// Compare two small text files after normalizing them. Returns false,
+// leaving the result unset, if the files are too large or binary, so
+// that the caller compares them as usual.
+func (self *treeEntry) compareTextFiles(options *DifftreeOptions) bool {
+	limit := options.textSizeLimit()
+	if self.info1.Size() > limit || self.info2.Size() > limit {
+		return false
+	}
+
+	data1, err := readFile(self.path1, options)
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return true
+	}
+	data2, err := readFile(self.path2, options)
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return true
+	}
+	self.bytesRead += int64(len(data1) + len(data2))
+	if isBinary(data1) || isBinary(data2) {
+		return false
+	}
+
+	if bytes.Equal(data1, data2) {
+		self.result = kPerfectMatch
+		return true
+	}
+
+	norm1, applied1 := normalizeText(data1, options)
+	norm2, applied2 := normalizeText(data2, options)
+	if bytes.Equal(norm1, norm2) {
+		self.result = kNormalizedMatch
+		self.description = "files match after normalizing: " +
+			strings.Join(mergeNames(applied1, applied2), ", ")
+		return true
+	}
+
+	self.result = kMismatch
+	if len(data1) != len(data2) {
+		self.description = describeSizes(int64(len(data1)), int64(len(data2)))
+	} else {
+		self.description = "text contents differ"
+	}
+	return true
+}
+
+// Merge two lists of names, keeping the order of first appearance
+func mergeNames(names1 []string, names2 []string) []string {
+	var merged []string
+	seen := make(map[string]bool)
+	for _, name := range append(names1, names2...) {
+		if !seen[name] {
+			seen[name] = true
+			merged = append(merged, name)
+		}
+	}
+	return merged
+}
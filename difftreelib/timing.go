@@ -0,0 +1,46 @@
+package difftreelib
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Wall-clock time spent in each stage of the pipeline, with Timing.
+// The stages overlap, so they don't add up to the total.
+type stageTiming struct {
+	// Accessed atomically, by all of the workers; keep it first
+	// in the struct for 64-bit alignment
+	compareNanos int64
+
+	walk    time.Duration
+	report  time.Duration
+	total   time.Duration
+	workers int
+}
+
+func (self *stageTiming) addCompare(start time.Time) {
+	atomic.AddInt64(&self.compareNanos, int64(time.Since(start)))
+}
+
+func recordDuration(duration *time.Duration, start time.Time) {
+	*duration += time.Since(start)
+}
+
+// Print how long each stage took. This is only collected when the
+// Timing option is set.
+func (s *ComparisonEngine) SummarizeTiming() {
+	fmt.Printf(`
+TIMING
+========================================
+Reading tree entries:           %12v
+Comparing (sum of %3d workers): %12v
+Reporting:                      %12v
+Total:                          %12v
+`,
+		s.timing.walk.Round(time.Microsecond),
+		s.timing.workers,
+		time.Duration(atomic.LoadInt64(&s.timing.compareNanos)).Round(time.Microsecond),
+		s.timing.report.Round(time.Microsecond),
+		s.timing.total.Round(time.Microsecond))
+}
@@ -4,11 +4,14 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/deckarep/golang-set" // mapset
 )
@@ -27,52 +30,209 @@ const (
 	kDirDifferentEntries
 	kError
 	kIgnored
+	kExtra // path is missing in tree1
+	kDifferentTargets
+	kNormalizedMatch // text files match only after normalization
+	kEscapingSymlink // a symlink points outside its tree
+	kDifferentBirthtime
+	kNotSampled // skipped by SampleRate
+	kDirNotCompared
+	kMetadataDiffers // contents match; with CombineMetadataDiffs
+	kMoved           // missing from tree2, but at another path in tree2
+	kDifferentContentType
+	kBaselined // an expected difference, from DifftreeOptions.Baseline
+	kDifferentOwner
+	kSuspiciousPerms // with DifftreeOptions.FlagPermissions
+	kNotExecutable   // skipped by ExecutablesOnly
+	kDifferentXattrs
+	kDirDifferentDotfiles // with SeparateDotfileDiffs
+	// With CompareResolvedContent, symlinks whose targets differ, but
+	// resolve to the same contents, and vice versa
+	kSameResolvedContent
+	kDifferentResolvedContent
+	kDirDifferentMtime // with CheckDirMtime
+	kDirSameSubtree    // pruned by DirectoryMerkle
+	kCaseRename        // with DetectCaseRenames
+	kDirDifferentAttrs // with CompareDirEntryAttrs
+	kAcceptedPerms     // a change in AllowedPermChanges
+	kDifferentProjectID
+	kDirMtimePrecision // with MtimePrecisionOnly
+	kDifferentGeneration
+	kTruncated // with DetectTruncated
 )
 
+// The tags used for each result in the report
+var resultTags = map[resultType]string{
+	kNil:                      "DTNil",
+	kPerfectMatch:             "DTPerfectMatch",
+	kMissing:                  "DTMissing",
+	kGoodEnough:               "DTGoodEnough",
+	kMismatch:                 "DTMismatch",
+	kDifferentTypes:           "DTDiffTypes",
+	kDifferentPermissions:     "DTDiffPerms",
+	kDirSameEntries:           "DTSameEntries",
+	kDirDifferentEntries:      "DTDiffEntries",
+	kError:                    "DTError",
+	kIgnored:                  "DTIgnored",
+	kExtra:                    "DTExtra",
+	kDifferentTargets:         "DTDiffTarget",
+	kNormalizedMatch:          "DTNormalized",
+	kEscapingSymlink:          "DTEscapes",
+	kDifferentBirthtime:       "DTDiffBirthtime",
+	kNotSampled:               "DTNotSampled",
+	kDirNotCompared:           "DTDirNotCompared",
+	kMetadataDiffers:          "DTDiffMetadata",
+	kMoved:                    "DTMoved",
+	kDifferentContentType:     "DTDiffContentType",
+	kBaselined:                "DTBaselined",
+	kDifferentOwner:           "DTDiffOwner",
+	kSuspiciousPerms:          "DTSuspiciousPerms",
+	kNotExecutable:            "DTNotExecutable",
+	kDifferentXattrs:          "DTDiffXattrs",
+	kDirDifferentDotfiles:     "DTDiffDotfiles",
+	kSameResolvedContent:      "DTSameResolved",
+	kDifferentResolvedContent: "DTDiffResolved",
+	kDirDifferentMtime:        "DTDiffDirMtime",
+	kDirSameSubtree:           "DTSameSubtree",
+	kCaseRename:               "DTCaseRename",
+	kDirDifferentAttrs:        "DTDiffEntryAttrs",
+	kAcceptedPerms:            "DTAcceptedPerms",
+	kDifferentProjectID:       "DTDiffProjectID",
+	kDirMtimePrecision:        "DTMtimePrecision",
+	kDifferentGeneration:      "DTDiffGeneration",
+	kTruncated:                "DTTruncated",
+}
+
+func (self resultType) String() string {
+	if tag, has := resultTags[self]; has {
+		return tag
+	}
+	return fmt.Sprintf("DTUnknown(%d)", int(self))
+}
+
 type treeEntry struct {
 	order       int
 	path1       string
 	path2       string
+	relPath     string
 	info1       os.FileInfo
 	info2       os.FileInfo
 	hasInfo2    bool
+	sampled     bool
 	err         error
 	result      resultType
 	description string
+
+	// The hashes of regular files, if they were compared by them
+	hash1 []byte
+	hash2 []byte
+
+	// With HashSampleRate, whether to hash the files if their sizes
+	// match, and then, whether they were hashed or assumed to match
+	hashSampled  bool
+	hashVerified bool
+	hashAssumed  bool
+
+	// With Timing or Profile, how long comparePaths took
+	compareTime time.Duration
+
+	// How many bytes of content comparePaths read, for
+	// MaxTotalBytesRead
+	bytesRead int64
+
+	// A change of permissions in AllowedPermChanges
+	acceptedPerms string
+
+	// With MtimePrecisionOnly, mtimes which differ only in precision
+	mtimePrecision string
+
+	// With SkipMatched, the files matched before, and haven't changed
+	skippedMatched bool
+
+	// Names present in only one of two compared directories
+	dir1Extra mapset.Set
+	dir2Extra mapset.Set
+
+	// With PrerenderOutput, the report and event of the result, and
+	// what they were rendered from
+	renderedText        []byte
+	renderedEvent       []byte
+	renderedResult      resultType
+	renderedDescription string
 }
 
 func (self *treeEntry) reset() {
 	self.order = 0
 	self.path1 = ""
 	self.path2 = ""
+	self.relPath = ""
 	self.info1 = nil
 	self.info2 = nil
 	self.hasInfo2 = false
+	self.sampled = false
+	self.hashSampled = false
+	self.hashVerified = false
+	self.hashAssumed = false
 	self.err = nil
 	self.result = kNil
 	self.description = ""
+	self.hash1 = nil
+	self.hash2 = nil
+	self.compareTime = 0
+	self.bytesRead = 0
+	self.acceptedPerms = ""
+	self.mtimePrecision = ""
+	self.skippedMatched = false
+	self.dir1Extra = nil
+	self.dir2Extra = nil
+	self.renderedText = nil
+	self.renderedEvent = nil
+	self.renderedResult = kNil
+	self.renderedDescription = ""
+}
+
+func (self *treeEntry) toResult(relativePath string) Result {
+	return Result{
+		Path:        relativePath,
+		Result:      self.result.String(),
+		Description: self.description,
+		Err:         self.err,
+	}
 }
 
-func (self *treeEntry) computePath2(path1RootLen int, path2Root string) {
+func (self *treeEntry) computePath2(path1RootLen int, path2Root string,
+	pathMap func(string) string) {
+
 	if len(self.path1) > path1RootLen {
-		self.path2 = filepath.Join(path2Root, self.path1[path1RootLen:])
+		relPath := self.path1[path1RootLen:]
+		if pathMap != nil {
+			relPath = pathMap(relPath)
+		}
+		self.path2 = filepath.Join(path2Root, relPath)
 	} else {
 		self.path2 = path2Root
 	}
 }
 
+// Test bits rather than comparing for equality, as a mode can
+// carry several bits at once: a character device has both ModeDevice
+// and ModeCharDevice, and callers may pass sticky or setuid bits too.
 func translateModeType(fileType os.FileMode) string {
-	switch fileType {
-	case os.ModeDir:
+	switch {
+	case fileType&os.ModeDir != 0:
 		return "directory"
-	case os.ModeSymlink:
+	case fileType&os.ModeSymlink != 0:
 		return "symlink"
-	case os.ModeNamedPipe:
+	case fileType&os.ModeNamedPipe != 0:
 		return "named pipe"
-	case os.ModeSocket:
+	case fileType&os.ModeSocket != 0:
 		return "socket"
-	case os.ModeDevice:
-		return "device"
+	case fileType&os.ModeCharDevice != 0:
+		return "character device"
+	case fileType&os.ModeDevice != 0:
+		return "block device"
+	case fileType&os.ModeIrregular != 0:
+		return "irregular file"
 	default:
 		return "regular file"
 	}
@@ -83,13 +243,25 @@ func (self *treeEntry) comparePaths(options *DifftreeOptions) {
 	if self.result == kIgnored {
 		panic(fmt.Sprintf("%s is ignored but compared", self.path1))
 	}
+	if options.ExecutablesOnly && self.info1.Mode().IsRegular() &&
+		self.info1.Mode().Perm()&0111 == 0 {
+		self.result = kNotExecutable
+		return
+	}
 	if !self.hasInfo2 {
-		self.info2, statErr = os.Lstat(self.path2)
+		statErr = options.retry(func() error {
+			var err error
+			self.info2, err = os.Lstat(self.path2)
+			return err
+		})
 		if statErr != nil {
 			// Is path2 missing?
 			if os.IsNotExist(statErr) {
-				log.Printf("Missing %s", self.path2)
+				logDebug("Missing %s", self.path2)
 				self.result = kMissing
+				if options.RecordAllHashes {
+					self.recordHashes(options)
+				}
 				return
 			}
 			// path2 had some other error
@@ -101,52 +273,169 @@ func (self *treeEntry) comparePaths(options *DifftreeOptions) {
 		self.hasInfo2 = true
 	}
 
+	if options.SkipSameInode && sameInode(self.info1, self.info2) {
+		self.result = kPerfectMatch
+		return
+	}
+
+	// Like FlagEscapingSymlinks, this warning takes the place of
+	// the comparison
+	if options.FlagPermissions != 0 && !options.StructureOnly &&
+		self.flagSuspiciousPerms(options) {
+		return
+	}
+
 	// Same inode types?
 	type1 := self.info1.Mode() & os.ModeType
 	type2 := self.info2.Mode() & os.ModeType
 	if type1 != type2 {
 		self.result = kDifferentTypes
-		self.description = fmt.Sprintf("file1 is a %s, but file2 is a %s",
-			translateModeType(type1), translateModeType(type2))
+		if options.ShowFullModeDiff {
+			self.description = self.describeFullModes()
+		} else {
+			self.description = fmt.Sprintf("file1 is a %s, but file2 is a %s",
+				translateModeType(type1), translateModeType(type2))
+		}
 		return
 	}
 
-	// Same permissions?
-	if self.info1.Mode().Perm() != self.info2.Mode().Perm() {
-		self.result = kDifferentPermissions
-		self.description = fmt.Sprintf("file1 has perms %s, but file2 has %s",
-			self.info1.Mode().String(), self.info2.Mode().String())
+	// The types were all there was to compare, but the names of the
+	// entries of directories
+	if options.StructureOnly {
+		if self.info1.IsDir() {
+			self.compareContents(options)
+		} else {
+			self.result = kPerfectMatch
+		}
 		return
 	}
 
+	// Same metadata? Normally, the first difference is the result,
+	// but it can be combined with the result of comparing contents.
+	diffs := self.compareMetadata(options, options.CombineMetadataDiffs)
+	if len(diffs) > 0 && !options.CombineMetadataDiffs {
+		self.result = diffs[0].result
+		self.description = diffs[0].description
+		return
+	}
+
+	// The metadata of these was all there was to compare
+	if !self.info1.IsDir() && len(options.MetadataOnlyPatterns) > 0 &&
+		options.metadataOnly(self.relPath) {
+		self.result = kPerfectMatch
+	} else {
+		self.compareContents(options)
+	}
+
+	if len(diffs) > 0 {
+		self.combineMetadataDiffs(diffs)
+	}
+
+	if self.acceptedPerms != "" &&
+		(self.result == kPerfectMatch || self.result == kDirSameEntries) {
+		self.result = kAcceptedPerms
+		self.description = self.acceptedPerms
+	}
+	if self.mtimePrecision != "" && self.result == kDirSameEntries {
+		self.result = kDirMtimePrecision
+		self.description = self.mtimePrecision
+	}
+}
+
+func (self *treeEntry) compareContents(options *DifftreeOptions) {
 	// Are these directories?
 	if self.info1.IsDir() {
+		if options.SkipDirectoryComparison {
+			self.result = kDirNotCompared
+			return
+		}
 		self.compareDirectories(options)
 		return
 	}
 
-	// TODO - compare symlinks
+	if isLink(self.info1) {
+		self.compareSymlinks(options)
+		return
+	}
 
 	self.compareRegularFiles(options)
 }
 
-func readDirectoryIntoSet(directory string, options *DifftreeOptions) (mapset.Set, error) {
+// With CompareDirEntryAttrs, this also returns the entries' infos,
+// by name
+func readDirectoryIntoSet(directory string, relDir string,
+	options *DifftreeOptions) (mapset.Set, map[string]os.FileInfo, error) {
+
 	// We don't need locking as we're the only goroutine
 	// that will access this set
 	set := mapset.NewThreadUnsafeSet()
 
 	dirEntries, err := ioutil.ReadDir(directory)
 	if err != nil {
-		return nil, fmt.Errorf("ReadDir(%s)", directory)
+		return nil, nil, fmt.Errorf("ReadDir(%s)", directory)
+	}
+
+	var infos map[string]os.FileInfo
+	if options.CompareDirEntryAttrs {
+		infos = make(map[string]os.FileInfo, len(dirEntries))
 	}
 
 	for _, dirEntry := range dirEntries {
 		if _, has := options.IgnoreFiles[dirEntry.Name()]; has {
 			continue
 		}
+		if options.IgnoreDotfiles && isDotfile(dirEntry.Name()) {
+			continue
+		}
+		if dirEntry.IsDir() && options.excludesDir(filepath.Join(relDir, dirEntry.Name())) {
+			continue
+		}
 		set.Add(dirEntry.Name())
+		if infos != nil {
+			infos[dirEntry.Name()] = dirEntry
+		}
+	}
+	return set, infos, nil
+}
+
+// Count the entries in a directory without stat'ing each of them,
+// as ioutil.ReadDir does.
+func countDirectoryEntries(directory string, options *DifftreeOptions) (int, error) {
+	f, err := os.Open(directory)
+	if err != nil {
+		return 0, fmt.Errorf("Open(%s): %v", directory, err)
+	}
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return 0, fmt.Errorf("Readdirnames(%s): %v", directory, err)
+	}
+
+	count := 0
+	for _, name := range names {
+		if _, has := options.IgnoreFiles[name]; has {
+			continue
+		}
+		if options.IgnoreDotfiles && isDotfile(name) {
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+func isDotfile(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+func allDotfiles(set mapset.Set) bool {
+	for _, item := range set.ToSlice() {
+		if !isDotfile(item.(string)) {
+			return false
+		}
 	}
-	return set, nil
+	return true
 }
 
 func createEnumeratedList(set mapset.Set) string {
@@ -160,14 +449,40 @@ func createEnumeratedList(set mapset.Set) string {
 
 func (self *treeEntry) compareDirectories(options *DifftreeOptions) {
 
-	dir1Set, err := readDirectoryIntoSet(self.path1, options)
+	// ExcludeDirs needs to know which entries are directories,
+	// so the counts can't be trusted when it's in use, and
+	// SeparateDotfileDiffs needs to know which entries differ.
+	if options.QuickDirCompare && len(options.ExcludeDirs) == 0 &&
+		!options.SeparateDotfileDiffs {
+		count1, err := countDirectoryEntries(self.path1, options)
+		if err != nil {
+			self.result = kError
+			self.err = err
+			return
+		}
+		count2, err := countDirectoryEntries(self.path2, options)
+		if err != nil {
+			self.result = kError
+			self.err = err
+			return
+		}
+		if count1 != count2 {
+			self.result = kDirDifferentEntries
+			self.description = fmt.Sprintf(
+				"dir1 has %d entries, dir2 has %d entries\n\n",
+				count1, count2)
+			return
+		}
+	}
+
+	dir1Set, infos1, err := readDirectoryIntoSet(self.path1, self.relPath, options)
 	if err != nil {
 		self.result = kError
 		self.err = err
 		return
 	}
 
-	dir2Set, err := readDirectoryIntoSet(self.path2, options)
+	dir2Set, infos2, err := readDirectoryIntoSet(self.path2, self.relPath, options)
 	if err != nil {
 		self.result = kError
 		self.err = err
@@ -176,12 +491,20 @@ func (self *treeEntry) compareDirectories(options *DifftreeOptions) {
 
 	if dir1Set.Equal(dir2Set) {
 		self.result = kDirSameEntries
+		if options.CompareDirEntryAttrs {
+			self.compareEntryAttrs(infos1, infos2, options)
+		}
 		return
 	}
 
 	self.result = kDirDifferentEntries
 	dir1extra := dir1Set.Difference(dir2Set)
 	dir2extra := dir2Set.Difference(dir1Set)
+	self.dir1Extra = dir1extra
+	self.dir2Extra = dir2extra
+	if options.SeparateDotfileDiffs && allDotfiles(dir1extra) && allDotfiles(dir2extra) {
+		self.result = kDirDifferentDotfiles
+	}
 
 	self.description = ""
 	if dir1extra.Cardinality() > 0 {
@@ -195,26 +518,58 @@ func (self *treeEntry) compareDirectories(options *DifftreeOptions) {
 	}
 }
 
+func getFileHash(filename string, options *DifftreeOptions) ([]byte, error) {
+	var hash []byte
+	err := options.retry(func() error {
+		var err error
+		switch options.HashAlgorithm {
+		case HashBLAKE3:
+			hash, err = getFileHashBLAKE3(filename, !options.RecordAllHashes)
+		default:
+			hash, err = getFileHashSHA1(filename)
+		}
+		return err
+	})
+	return hash, err
+}
+
 // While sha1 is cryptographically insecure, we don't care,
 // as we're only checking between two trees that we own.
 // Plus, it's faster than sha256
-func getFileHash(filename string) ([]byte, error) {
-	hasher := sha1.New()
-	f, err := os.Open(filename)
+func getFileHashSHA1(filename string) ([]byte, error) {
+	return hashFile(filename, sha1.New())
+}
+
+// Opens each file to hash; the tests replace it to make the filesystem
+// slow, or fail
+var openFile = os.Open
+
+func hashFile(filename string, hasher hash.Hash) ([]byte, error) {
+	f, err := openFile(filename)
 	if err != nil {
-		return nil, fmt.Errorf("Opening %e for hashing: %q",
+		return nil, fmt.Errorf("Opening %s for hashing: %w",
 			filename, err)
 	}
 	defer f.Close()
 
 	_, err = io.Copy(hasher, f)
 	if err != nil {
-		return nil, fmt.Errorf("Reading %e for hashing: %q",
+		return nil, fmt.Errorf("Reading %s for hashing: %w",
 			filename, err)
 	}
 	return hasher.Sum(nil), nil
 }
 
+func readFile(filename string, options *DifftreeOptions) ([]byte, error) {
+	var data []byte
+	err := options.retry(func() error {
+		var err error
+		data, err = ioutil.ReadFile(filename)
+		return err
+	})
+	return data, err
+}
+
 func cmpByteSlices(s1 []byte, s2 []byte) bool {
 	if len(s1) != len(s2) {
 		return false
@@ -227,42 +582,176 @@ func cmpByteSlices(s1 []byte, s2 []byte) bool {
 	return true
 }
 
+func describeSizes(size1 int64, size2 int64) string {
+	switch {
+	case size1 == 0:
+		return fmt.Sprintf("file1 is empty, file2 is size %d", size2)
+	case size2 == 0:
+		return fmt.Sprintf("file1 is size %d, file2 is empty", size1)
+	default:
+		return fmt.Sprintf("file1 is size %d, file2 is size %d", size1, size2)
+	}
+}
+
 func (self *treeEntry) compareRegularFiles(options *DifftreeOptions) {
+	if options.RecordAllHashes {
+		defer self.recordHashes(options)
+	}
+	if options.ShowSmallFileContents {
+		defer self.appendSmallFileContents(options)
+	}
+	if options.SimilarityCompare {
+		defer self.appendSimilarity(options)
+	}
+
+	if options.SkipMatched != nil && self.matchedBefore(options) {
+		self.result = kPerfectMatch
+		self.skippedMatched = true
+		return
+	}
+
 	// Does the size match? If not, it's immediately a mismatch,
 	// although in the future we could have smart plugins that
 	// examine only pertitenent parts of a file (like, ignoring
 	// .debug sections of ELF files)
-	if self.info1.Size() != self.info2.Size() {
-		self.description = fmt.Sprintf(
-			"file1 is size %d, file2 is size %d",
-			self.info1.Size(), self.info2.Size())
-		self.result = kMismatch
+	size1 := self.info1.Size()
+	size2 := self.info2.Size()
+	if options.IgnoreEmptyFiles && (size1 == 0 || size2 == 0) {
+		self.result = kIgnored
 		return
 	}
 
-	// Same size.... but same contents?
-	if options.CheckHashes {
-		hash1, err := getFileHash(self.path1)
-		if err != nil {
-			self.result = kError
-			self.err = err
+	// A change in the kind of file is reported instead of a mismatch
+	if options.CompareContentType && self.compareContentTypes() {
+		return
+	}
+
+	if options.CompareCommand != "" {
+		self.runCompareCommand(options)
+		return
+	}
+
+	// Text files may match after normalization even if their sizes differ
+	if options.normalizesText() && self.compareTextFiles(options) {
+		return
+	}
+
+	if size1 != size2 {
+		if options.DetectTruncated && options.CheckHashes && self.compareTruncated(options) {
+			return
 		}
-		hash2, err := getFileHash(self.path2)
-		if err != nil {
-			self.result = kError
-			self.err = err
+		if options.AlwaysHash {
+			self.compareHashes(options)
+			if self.result == kMismatch {
+				self.description = describeSizes(size1, size2) + "; " + self.description
+			}
+			return
 		}
+		self.description = describeSizes(size1, size2)
+		self.result = kMismatch
+		return
+	}
 
-		if cmpByteSlices(hash1, hash2) {
-			self.result = kPerfectMatch
-		} else {
-			self.result = kMismatch
-			self.description = fmt.Sprintf(
-				"file1 hash SHA1 %s, file2 has SHA1 %s",
-				hex.EncodeToString(hash1),
-				hex.EncodeToString(hash2))
-		}
+	// Two empty files; there's no content to check
+	if size1 == 0 {
+		self.result = kPerfectMatch
+		return
+	}
+
+	// Same size.... but same contents?
+	if len(options.IgnoreByteRanges) > 0 {
+		self.compareIgnoringRanges(options)
+	} else if options.BlockCompare {
+		self.bytesRead += size1 + size2
+		self.compareBlocks(options)
+	} else if options.CheckHashes {
+		self.compareHashes(options)
+	} else if self.hashSampled {
+		self.hashVerified = true
+		self.compareHashes(options)
 	} else {
+		self.hashAssumed = options.HashSampleRate > 0
+		self.result = kPerfectMatch
+	}
+}
+
+// Compare the contents of two regular files of the same size by their
+// hashes
+func (self *treeEntry) compareHashes(options *DifftreeOptions) {
+	hash1, err := getFileHash(self.path1, options)
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return
+	}
+	hash2, err := getFileHash(self.path2, options)
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return
+	}
+
+	self.hash1 = hash1
+	self.hash2 = hash2
+	self.bytesRead += self.info1.Size() + self.info2.Size()
+
+	if cmpByteSlices(hash1, hash2) {
 		self.result = kPerfectMatch
+	} else {
+		self.result = kMismatch
+		self.description = fmt.Sprintf(
+			"file1 has %s %s, file2 has %s %s",
+			options.fileHashName(self.info1.Size()), hex.EncodeToString(hash1),
+			options.fileHashName(self.info2.Size()), hex.EncodeToString(hash2))
+	}
+}
+
+// Whether the two are the same file, by their device and inode numbers.
+// Without those, they are not.
+func sameInode(info1 os.FileInfo, info2 os.FileInfo) bool {
+	dev1, ino1, ok1 := deviceAndInode(info1)
+	dev2, ino2, ok2 := deviceAndInode(info2)
+	return ok1 && ok2 && dev1 == dev2 && ino1 == ino2
+}
+
+// With CompareDirEntryAttrs, compare the types, and optionally the
+// sizes, of the entries which are in both directories, so that a
+// change of an entry is seen at the directory level too
+func (self *treeEntry) compareEntryAttrs(infos1 map[string]os.FileInfo,
+	infos2 map[string]os.FileInfo, options *DifftreeOptions) {
+
+	names := make([]string, 0, len(infos1))
+	for name := range infos1 {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var diffs []string
+	for _, name := range names {
+		info1 := infos1[name]
+		info2, has := infos2[name]
+		if !has {
+			continue
+		}
+		type1 := info1.Mode() & os.ModeType
+		type2 := info2.Mode() & os.ModeType
+		if type1 != type2 {
+			diffs = append(diffs, fmt.Sprintf("%s: a %s in dir1, a %s in dir2",
+				name, translateModeType(type1), translateModeType(type2)))
+		} else if options.CompareDirEntrySizes && info1.Mode().IsRegular() &&
+			info1.Size() != info2.Size() {
+			diffs = append(diffs, fmt.Sprintf("%s: %s", name,
+				describeSizes(info1.Size(), info2.Size())))
+		}
+	}
+	if len(diffs) == 0 {
+		return
+	}
+
+	self.result = kDirDifferentAttrs
+	self.description = "these entries differ between dir1 and dir2:\n"
+	for i, diff := range diffs {
+		self.description += fmt.Sprintf("    %4d. %s\n", i+1, diff)
 	}
+	self.description += "\n"
 }
@@ -0,0 +1,231 @@
+package difftreelib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHashErrorsNameTheFile(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	tests := []struct {
+		name      string
+		path      string
+		wantStart string
+	}{
+		{"cannot open", filepath.Join(dir, "nonexistent"), "Opening "},
+		{"cannot read", dir, "Reading "},
+	}
+	for _, test := range tests {
+		for _, algorithm := range []string{HashSHA1, HashBLAKE3} {
+			t.Run(test.name+" "+algorithm, func(t *testing.T) {
+				options := DifftreeOptions{HashAlgorithm: algorithm}
+				_, err := getFileHash(test.path, &options)
+				if err == nil {
+					t.Fatalf("getFileHash(%q) succeeded", test.path)
+				}
+				want := test.wantStart + test.path + " for hashing: "
+				if !strings.HasPrefix(err.Error(), want) {
+					t.Errorf("error = %q, want it to start with %q", err, want)
+				}
+			})
+		}
+	}
+
+	_, err := getFileHash(filepath.Join(dir, "nonexistent"), &DifftreeOptions{})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error = %v, want one which wraps the error not found", err)
+	}
+}
+
+func TestTranslateModeType(t *testing.T) {
+	tests := []struct {
+		mode os.FileMode
+		want string
+	}{
+		{0, "regular file"},
+		{0755, "regular file"},
+		{os.ModeSetuid | os.ModeSetgid | 0755, "regular file"},
+		{os.ModeDir, "directory"},
+		{os.ModeDir | os.ModeSticky | 01777, "directory"},
+		{os.ModeSymlink | 0777, "symlink"},
+		{os.ModeNamedPipe, "named pipe"},
+		{os.ModeSocket, "socket"},
+		{os.ModeDevice, "block device"},
+		{os.ModeDevice | os.ModeCharDevice, "character device"},
+		{os.ModeIrregular, "irregular file"},
+	}
+	for _, test := range tests {
+		if got := translateModeType(test.mode); got != test.want {
+			t.Errorf("translateModeType(%v) = %q, want %q", test.mode, got, test.want)
+		}
+	}
+}
+
+func TestCompareDirEntryAttrs(t *testing.T) {
+	path1, path2, cleanup := makeTrees(t,
+		testTree{"types/entry": "1", "sizes/entry": "1", "same/entry": "1"},
+		testTree{"types/entry/": "", "sizes/entry": "22", "same/entry": "1"})
+	defer cleanup()
+
+	tests := []struct {
+		name               string
+		options            DifftreeOptions
+		wantDifferentAttrs int
+		wantSame           int
+	}{
+		// The root, and the three directories
+		{"names only", DifftreeOptions{}, 0, 4},
+		{"types", DifftreeOptions{CompareDirEntryAttrs: true}, 1, 3},
+		{"types and sizes", DifftreeOptions{CompareDirEntryAttrs: true,
+			CompareDirEntrySizes: true}, 2, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := test.options
+			stats := compareQuietly(t, path1, path2, &options)
+			if stats.DirDifferentAttrs != test.wantDifferentAttrs {
+				t.Errorf("DirDifferentAttrs = %d, want %d", stats.DirDifferentAttrs,
+					test.wantDifferentAttrs)
+			}
+			if stats.DirSame != test.wantSame {
+				t.Errorf("DirSame = %d, want %d", stats.DirSame, test.wantSame)
+			}
+			// The entries themselves are still compared
+			if stats.DifferentTypes != 1 {
+				t.Errorf("DifferentTypes = %d, want 1", stats.DifferentTypes)
+			}
+		})
+	}
+}
+
+func TestAlwaysHash(t *testing.T) {
+	path1, path2, cleanup := makeTrees(t,
+		testTree{"short": "abc", "long": "abcdef", "same": "abc"},
+		testTree{"short": "abcdef", "long": "abc", "same": "abc"})
+	defer cleanup()
+	// The SHA1 of "abc", and of "abcdef"
+	abc := "a9993e364706816aba3e25717850c26c9cd0d89d"
+	abcdef := "1f8ac10f23c5b5bc1167bda84b833e5c057a77d2"
+
+	tests := []struct {
+		name       string
+		path       string
+		alwaysHash bool
+		want       string
+	}{
+		{"sizes differ", "short", false, "file1 is size 3, file2 is size 6"},
+		{"sizes differ, hashed", "short", true, "file1 is size 3, file2 is size 6; " +
+			"file1 has SHA1 " + abc + ", file2 has SHA1 " + abcdef},
+		{"longer, hashed", "long", true, "file1 is size 6, file2 is size 3; " +
+			"file1 has SHA1 " + abcdef + ", file2 has SHA1 " + abc},
+		{"same", "same", true, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := DifftreeOptions{CheckHashes: true, AlwaysHash: test.alwaysHash}
+			result, err := CompareFiles(filepath.Join(path1, test.path),
+				filepath.Join(path2, test.path), &options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantResult := kMismatch
+			if test.want == "" {
+				wantResult = kPerfectMatch
+			}
+			if result.Result != wantResult.String() || result.Description != test.want {
+				t.Errorf("CompareFiles() = %s %q, want %s %q", result.Result,
+					result.Description, wantResult, test.want)
+			}
+		})
+	}
+
+	var engine ComparisonEngine
+	options := DifftreeOptions{AlwaysHash: true, Quiet: true}
+	if err := engine.Compare(path1, path2, &options); err == nil {
+		t.Error("AlwaysHash without CheckHashes succeeded")
+	}
+}
+
+func TestQuickDirCompare(t *testing.T) {
+	path1, path2, cleanup := makeTrees(t,
+		testTree{"dotfiles/file": "1", "dotfiles/.cache": "1", "other/file": "1",
+			"other/extra": "1"},
+		testTree{"dotfiles/file": "1", "other/file": "1"})
+	defer cleanup()
+
+	tests := []struct {
+		name          string
+		options       DifftreeOptions
+		wantDifferent int
+		wantDotfiles  int
+	}{
+		{"quick", DifftreeOptions{QuickDirCompare: true}, 2, 0},
+		{"dotfiles", DifftreeOptions{SeparateDotfileDiffs: true}, 1, 1},
+		{"quick, with dotfiles", DifftreeOptions{QuickDirCompare: true,
+			SeparateDotfileDiffs: true}, 1, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := test.options
+			stats := compareQuietly(t, path1, path2, &options)
+			if stats.DirDifferent != test.wantDifferent ||
+				stats.DirDifferentDotfiles != test.wantDotfiles {
+				t.Errorf("DirDifferent = %d, DirDifferentDotfiles = %d, want %d and %d",
+					stats.DirDifferent, stats.DirDifferentDotfiles, test.wantDifferent,
+					test.wantDotfiles)
+			}
+		})
+	}
+}
+
+// Compare two directories of 100k entries, one more in dir2, by their
+// counts, and by their sets of names
+func BenchmarkQuickDirCompare(b *testing.B) {
+	dir, cleanup := tempDir(b)
+	defer cleanup()
+	path1 := filepath.Join(dir, "a")
+	path2 := filepath.Join(dir, "b")
+	for _, path := range []string{path1, path2} {
+		if err := os.Mkdir(path, 0755); err != nil {
+			b.Fatal(err)
+		}
+	}
+	const numEntries = 100000
+	for i := 0; i <= numEntries; i++ {
+		name := fmt.Sprintf("entry%06d", i)
+		paths := []string{filepath.Join(path2, name)}
+		if i < numEntries {
+			paths = append(paths, filepath.Join(path1, name))
+		}
+		for _, path := range paths {
+			f, err := os.Create(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			f.Close()
+		}
+	}
+
+	for _, quick := range []bool{false, true} {
+		name := "sets"
+		if quick {
+			name = "counts"
+		}
+		b.Run(name, func(b *testing.B) {
+			options := DifftreeOptions{QuickDirCompare: quick}
+			for i := 0; i < b.N; i++ {
+				entry := treeEntry{path1: path1, path2: path2}
+				entry.compareDirectories(&options)
+				if entry.result != kDirDifferentEntries {
+					b.Fatalf("result = %v", entry.result)
+				}
+			}
+		})
+	}
+}
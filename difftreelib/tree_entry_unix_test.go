@@ -0,0 +1,74 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package difftreelib
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// Every type of inode which can be made without privileges, and the
+// devices which are there already
+func TestTranslateModeTypeOfInodes(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	testTree{
+		"file":       "contents",
+		"directory/": "",
+		"symlink":    symlinkTo + "file",
+	}.make(t, dir)
+
+	fifo := filepath.Join(dir, "fifo")
+	if err := syscall.Mkfifo(fifo, 0644); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "socket")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{filepath.Join(dir, "file"), "regular file"},
+		{filepath.Join(dir, "directory"), "directory"},
+		{filepath.Join(dir, "symlink"), "symlink"},
+		{fifo, "named pipe"},
+		{socket, "socket"},
+		{"/dev/null", "character device"},
+		{"/dev/loop0", "block device"},
+		{"/dev/disk0", "block device"},
+	}
+	for _, test := range tests {
+		info, err := os.Lstat(test.path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			t.Fatal(err)
+		}
+		if got := translateModeType(info.Mode() & os.ModeType); got != test.want {
+			t.Errorf("%s is a %q, want %q", test.path, got, test.want)
+		}
+
+		if test.want == "regular file" {
+			continue
+		}
+		result, err := CompareFiles(test.path, filepath.Join(dir, "file"), &DifftreeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "file1 is a " + test.want + ", but file2 is a regular file"
+		if result.Result != kDifferentTypes.String() || result.Description != want {
+			t.Errorf("comparing %s with a regular file: %s %q, want %s %q", test.path,
+				result.Result, result.Description, kDifferentTypes, want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package difftreelib
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"lukechampine.com/blake3"
+)
+
+func newHasher(options *DifftreeOptions) hash.Hash {
+	switch options.HashAlgorithm {
+	case HashBLAKE3:
+		return blake3.New(blake3DigestSize, nil)
+	default:
+		return sha1.New()
+	}
+}
+
+// Compute a single hash over the whole tree, so that two trees can be
+// checked for equality by comparing their hashes. The hash covers each
+// path's relative path, type, permissions, size, and content hash
+// (or symlink target), in sorted order of relative paths, so identical
+// trees have identical hashes. Directory sizes are not included, as
+// they vary between filesystems. IgnoreFiles and ExcludeDirs are
+// honored; HashAlgorithm chooses the hash.
+func TreeHash(root string, options *DifftreeOptions) ([]byte, error) {
+	root = filepath.Clean(root)
+	var records []string
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		var relPath string
+		if path != root {
+			relPath = path[len(root)+1:]
+		}
+		if _, has := options.IgnoreFiles[info.Name()]; has && relPath != "" {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() && options.excludesDir(relPath) {
+			return filepath.SkipDir
+		}
+
+		fileType := info.Mode() & os.ModeType
+		var size int64
+		var content []byte
+		switch {
+		case fileType == 0:
+			size = info.Size()
+			content, err = getFileHash(path, options)
+			if err != nil {
+				return err
+			}
+		case isLink(info):
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			content = []byte(target)
+		}
+
+		records = append(records, fmt.Sprintf("%s\x00%s\x00%o\x00%d\x00%x\n",
+			filepath.ToSlash(relPath), translateModeType(fileType),
+			info.Mode().Perm(), size, content))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(records)
+
+	hasher := newHasher(options)
+	for _, record := range records {
+		hasher.Write([]byte(record))
+	}
+	return hasher.Sum(nil), nil
+}
@@ -0,0 +1,69 @@
+package difftreelib
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// For TreeView, the differences are kept in a trie of path components,
+// which is printed, sorted by name, after the comparison. Only the
+// directories leading to differences are in the trie, so subtrees
+// without differences aren't shown.
+type treeViewNode struct {
+	children map[string]*treeViewNode
+	// kNil for a directory which only leads to differences
+	result resultType
+}
+
+func newTreeViewNode() *treeViewNode {
+	return &treeViewNode{children: make(map[string]*treeViewNode)}
+}
+
+func (self *treeViewNode) add(relPath string, result resultType) {
+	node := self
+	if relPath != "" {
+		for _, name := range strings.Split(relPath, string(filepath.Separator)) {
+			child, has := node.children[name]
+			if !has {
+				child = newTreeViewNode()
+				node.children[name] = child
+			}
+			node = child
+		}
+	}
+	node.result = result
+}
+
+// Print the trie, as in:
+//
+//	./ DTDiffEntries
+//	    cache/ DTDiffEntries
+//	        f DTMissing
+//	    x/
+//	        cache DTMissing
+func (self *treeViewNode) print() {
+	self.printNode(".", 0)
+	fmt.Print("\n")
+}
+
+func (self *treeViewNode) printNode(name string, depth int) {
+	if len(self.children) > 0 {
+		name += "/"
+	}
+	if self.result == kNil {
+		fmt.Printf("%s%s\n", strings.Repeat("    ", depth), name)
+	} else {
+		fmt.Printf("%s%s %s\n", strings.Repeat("    ", depth), name, self.result)
+	}
+
+	names := make([]string, 0, len(self.children))
+	for childName := range self.children {
+		names = append(names, childName)
+	}
+	sort.Strings(names)
+	for _, childName := range names {
+		self.children[childName].printNode(childName, depth+1)
+	}
+}
@@ -0,0 +1,69 @@
+package difftreelib
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Hash the first length bytes of a file
+func hashPrefix(filename string, length int64, options *DifftreeOptions) ([]byte, error) {
+	var hash []byte
+	err := options.retry(func() error {
+		f, err := os.Open(filename)
+		if err != nil {
+			return fmt.Errorf("Opening %s for hashing: %w", filename, err)
+		}
+		defer f.Close()
+
+		hasher := newHasher(options)
+		if _, err := io.CopyN(hasher, f, length); err != nil {
+			return fmt.Errorf("Reading %s for hashing: %w", filename, err)
+		}
+		hash = hasher.Sum(nil)
+		return nil
+	})
+	return hash, err
+}
+
+// For DetectTruncated, when the sizes differ, check whether the
+// smaller file is the start of the larger one, as when a copy was
+// interrupted, by hashing that much of each. Returns false, leaving
+// the result unset, if it isn't.
+func (self *treeEntry) compareTruncated(options *DifftreeOptions) bool {
+	size1 := self.info1.Size()
+	size2 := self.info2.Size()
+	shared := size1
+	if size2 < shared {
+		shared = size2
+	}
+
+	hash1, err := hashPrefix(self.path1, shared, options)
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return true
+	}
+	hash2, err := hashPrefix(self.path2, shared, options)
+	if err != nil {
+		self.result = kError
+		self.err = err
+		return true
+	}
+	self.bytesRead += 2 * shared
+	if !cmpByteSlices(hash1, hash2) {
+		return false
+	}
+
+	self.result = kTruncated
+	if size2 < size1 {
+		self.description = fmt.Sprintf(
+			"file2 appears to be a truncated copy of file1: it has %d of its %d bytes",
+			size2, size1)
+	} else {
+		self.description = fmt.Sprintf(
+			"file1 appears to be a truncated copy of file2: it has %d of its %d bytes",
+			size1, size2)
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package difftreelib
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Each way that the walk of tree1 can end early, with entries still in
+// the pipeline, must let Compare return
+func TestWalkEndsEarly(t *testing.T) {
+	tree1 := make(testTree)
+	tree2 := make(testTree)
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("dir%d/file%d", i%10, i)
+		tree1[name] = "contents"
+		tree2[name] = fmt.Sprintf("other contents %d", i)
+	}
+	path1, path2, cleanup := makeTrees(t, tree1, tree2)
+	defer cleanup()
+
+	tests := []struct {
+		name       string
+		options    DifftreeOptions
+		stop       bool
+		wantReason string
+	}{
+		{"deadline", DifftreeOptions{Deadline: time.Nanosecond}, false,
+			"the deadline passed"},
+		{"read budget", DifftreeOptions{CheckHashes: true, AlwaysHash: true,
+			MaxTotalBytesRead: 1}, false, "the read budget of 1 bytes was exhausted"},
+		{"first difference", DifftreeOptions{StopOnFirstDiff: true}, false,
+			"a difference was found"},
+		{"stopped", DifftreeOptions{}, true, "the comparison was interrupted"},
+	}
+	for _, test := range tests {
+		for _, partition := range []bool{false, true} {
+			name := test.name
+			if partition {
+				name += " with partitions"
+			}
+			t.Run(name, func(t *testing.T) {
+				options := test.options
+				options.Quiet = true
+				options.Workers = 2
+				options.PipelineDepth = 1
+				options.PartitionTopLevel = partition
+
+				var engine ComparisonEngine
+				if test.stop {
+					engine.Stop()
+				}
+				done := make(chan error, 1)
+				go func() {
+					done <- engine.Compare(path1, path2, &options)
+				}()
+
+				select {
+				case err := <-done:
+					if err != nil {
+						t.Fatalf("Compare: %v", err)
+					}
+				case <-time.After(10 * time.Second):
+					t.Fatal("Compare did not return")
+				}
+				stats := engine.Stats()
+				if !stats.Incomplete || stats.IncompleteReason != test.wantReason {
+					t.Errorf("Incomplete = %v, IncompleteReason = %q, want %q",
+						stats.Incomplete, stats.IncompleteReason, test.wantReason)
+				}
+			})
+		}
+	}
+}
+
+// Without a blank entry, the walk stops, and passes nothing on
+func TestWalkWithoutBlankEntries(t *testing.T) {
+	path1, path2, cleanup := makeTrees(t,
+		testTree{"file": "1"},
+		testTree{"file": "1"})
+	defer cleanup()
+
+	var engine ComparisonEngine
+	engine.path1RootLen = rootPrefixLen(path1)
+	blankEntryChan := make(chan *treeEntry)
+	close(blankEntryChan)
+	filledEntryChan := make(chan *treeEntry, 1)
+
+	done := make(chan struct{})
+	go func() {
+		engine.readTreeEntries(path1, path2, blankEntryChan, filledEntryChan,
+			&DifftreeOptions{})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("readTreeEntries did not return")
+	}
+	if entry, ok := <-filledEntryChan; ok {
+		t.Errorf("passed on %+v", entry)
+	}
+}
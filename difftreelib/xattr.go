@@ -0,0 +1,67 @@
+package difftreelib
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Returns true if the extended attributes differ, other than those in
+// IgnoreXattrs. If either path's can't be read, they are not compared.
+func (self *treeEntry) compareXattrs(options *DifftreeOptions) (metadataDiff, bool) {
+	xattrs1, ok1, err := readXattrs(self.path1)
+	if err != nil {
+		logError("Cannot read the xattrs of %s: %v", self.path1, err)
+		return metadataDiff{}, false
+	}
+	xattrs2, ok2, err := readXattrs(self.path2)
+	if err != nil {
+		logError("Cannot read the xattrs of %s: %v", self.path2, err)
+		return metadataDiff{}, false
+	}
+	if !ok1 || !ok2 {
+		return metadataDiff{}, false
+	}
+
+	ignored := make(map[string]bool, len(options.IgnoreXattrs))
+	for _, name := range options.IgnoreXattrs {
+		ignored[name] = true
+	}
+
+	var names []string
+	for name := range xattrs1 {
+		names = append(names, name)
+	}
+	for name := range xattrs2 {
+		if _, has := xattrs1[name]; !has {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var diffs []string
+	for _, name := range names {
+		if ignored[name] {
+			continue
+		}
+		value1, has1 := xattrs1[name]
+		value2, has2 := xattrs2[name]
+		switch {
+		case !has2:
+			diffs = append(diffs, fmt.Sprintf("%s is only on file1", name))
+		case !has1:
+			diffs = append(diffs, fmt.Sprintf("%s is only on file2", name))
+		case !bytes.Equal(value1, value2):
+			diffs = append(diffs, fmt.Sprintf("%s differs", name))
+		}
+	}
+	if len(diffs) == 0 {
+		return metadataDiff{}, false
+	}
+
+	return metadataDiff{
+		result:      kDifferentXattrs,
+		description: "xattrs differ: " + strings.Join(diffs, ", "),
+	}, true
+}
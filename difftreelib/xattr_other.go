@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+// +build !linux,!darwin,!freebsd,!netbsd
+
+package difftreelib
+
+// Extended attributes are not available on this platform
+func readXattrs(path string) (map[string][]byte, bool, error) {
+	return nil, false, nil
+}
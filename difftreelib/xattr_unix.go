@@ -0,0 +1,78 @@
+//go:build linux || darwin || freebsd || netbsd
+// +build linux darwin freebsd netbsd
+
+package difftreelib
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Read the extended attributes of a path, without following symlinks.
+// A filesystem without extended attributes has none.
+func readXattrs(path string) (map[string][]byte, bool, error) {
+	names, err := listXattrNames(path)
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, true, err
+	}
+
+	xattrs := make(map[string][]byte, len(names))
+	for _, name := range names {
+		value, err := getXattr(path, name)
+		if err != nil {
+			return nil, true, err
+		}
+		xattrs[name] = value
+	}
+	return xattrs, true, nil
+}
+
+func listXattrNames(path string) ([]string, error) {
+	for {
+		size, err := unix.Llistxattr(path, nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		size, err = unix.Llistxattr(path, buf)
+		// The list grew after it was measured
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var names []string
+		for _, name := range strings.Split(string(buf[:size]), "\x00") {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+		return names, nil
+	}
+}
+
+func getXattr(path string, name string) ([]byte, error) {
+	for {
+		size, err := unix.Lgetxattr(path, name, nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		size, err = unix.Lgetxattr(path, name, buf)
+		// The value grew after it was measured
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:size], nil
+	}
+}
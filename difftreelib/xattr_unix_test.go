@@ -0,0 +1,61 @@
+//go:build linux || darwin || freebsd || netbsd
+// +build linux darwin freebsd netbsd
+
+package difftreelib
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestIgnoreXattrs(t *testing.T) {
+	path1, path2, cleanup := makeTrees(t,
+		testTree{"envonly": "1", "both": "1"},
+		testTree{"envonly": "1", "both": "1"})
+	defer cleanup()
+
+	xattrs := []struct {
+		path  string
+		name  string
+		value string
+	}{
+		{filepath.Join(path1, "envonly"), "user.env", "host1"},
+		{filepath.Join(path2, "envonly"), "user.env", "host2"},
+		{filepath.Join(path1, "both"), "user.env", "host1"},
+		{filepath.Join(path2, "both"), "user.env", "host2"},
+		{filepath.Join(path1, "both"), "user.sig", "signed"},
+		{filepath.Join(path2, "both"), "user.sig", "tampered"},
+	}
+	for _, xattr := range xattrs {
+		err := unix.Lsetxattr(xattr.path, xattr.name, []byte(xattr.value), 0)
+		if err == unix.ENOTSUP || err == unix.EPERM {
+			t.Skipf("Cannot set xattrs in %s: %v", path1, err)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		ignore     []string
+		wantDiffer int
+	}{
+		{"none ignored", nil, 2},
+		{"one ignored", []string{"user.env"}, 1},
+		{"another ignored", []string{"user.other"}, 2},
+		{"both ignored", []string{"user.env", "user.sig"}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := DifftreeOptions{CheckXattrs: true, IgnoreXattrs: test.ignore}
+			stats := compareQuietly(t, path1, path2, &options)
+			if stats.DifferentXattrs != test.wantDiffer {
+				t.Errorf("DifferentXattrs = %d, want %d", stats.DifferentXattrs,
+					test.wantDiffer)
+			}
+		})
+	}
+}
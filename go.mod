@@ -5,5 +5,8 @@ go 1.13
 require (
 	github.com/deckarep/golang-set v1.7.1
 	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
+	golang.org/x/sys v0.0.0-20210423082822-04245dca01da
 	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f
+	lukechampine.com/blake3 v1.1.7
+	modernc.org/sqlite v1.10.0
 )